@@ -2,7 +2,7 @@ package database
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
@@ -16,15 +16,50 @@ import (
 )
 
 type Database struct {
-	mx                         sync.Mutex
-	db                         *pgx.Conn
-	getArticlesStmt            *pgconn.StatementDescription
-	putInArticlesStmt          *pgconn.StatementDescription
-	putInformationInHabsStmt   *pgconn.StatementDescription
-	getFromHabsInformationStmt *pgconn.StatementDescription
-	getHabInfoStmt             *pgconn.StatementDescription
-	deleteHabStmt              *pgconn.StatementDescription
-	deleteArticlesStmt         *pgconn.StatementDescription
+	mx                          sync.Mutex
+	db                          *pgx.Conn
+	getArticlesStmt             *pgconn.StatementDescription
+	getStoredArticleUrlsStmt    *pgconn.StatementDescription
+	getArticleUrlsToReparseStmt *pgconn.StatementDescription
+	putTagsStmt                 *pgconn.StatementDescription
+	putArticleMetricsStmt       *pgconn.StatementDescription
+	getArticleMetricsStmt       *pgconn.StatementDescription
+	getArticlesForRevisitStmt   *pgconn.StatementDescription
+	deleteArticleTagsStmt       *pgconn.StatementDescription
+	putArticleTagsStmt          *pgconn.StatementDescription
+	getTagsStmt                 *pgconn.StatementDescription
+	getLastArticleUrlsStmt      *pgconn.StatementDescription
+	putInArticlesStmt           *pgconn.StatementDescription
+	putInformationInHabsStmt    *pgconn.StatementDescription
+	putHabStateStmt             *pgconn.StatementDescription
+	getFromHabsInformationStmt  *pgconn.StatementDescription
+	getHabInfoStmt              *pgconn.StatementDescription
+	updateHabDefinitionStmt     *pgconn.StatementDescription
+	deleteHabStmt               *pgconn.StatementDescription
+	deleteArticlesStmt          *pgconn.StatementDescription
+	putBackfillStmt             *pgconn.StatementDescription
+	getBackfillsStmt            *pgconn.StatementDescription
+	getArticleLastmodsStmt      *pgconn.StatementDescription
+	getArticleDatesStmt         *pgconn.StatementDescription
+	getSitemapLastmodStmt       *pgconn.StatementDescription
+	putSitemapLastmodStmt       *pgconn.StatementDescription
+	deleteSitemapsStmt          *pgconn.StatementDescription
+	putDeadLetterStmt           *pgconn.StatementDescription
+	getDeadLettersStmt          *pgconn.StatementDescription
+	getDeadLetterStmt           *pgconn.StatementDescription
+	getDeadLetterUrlsStmt       *pgconn.StatementDescription
+	deleteDeadLetterStmt        *pgconn.StatementDescription
+	deleteHabDeadLettersStmt    *pgconn.StatementDescription
+	putCrawlRunStmt             *pgconn.StatementDescription
+	getCrawlRunStmt             *pgconn.StatementDescription
+	getCrawlRunsStmt            *pgconn.StatementDescription
+	getCrawlRunStatsStmt        *pgconn.StatementDescription
+	getDeadLettersCountStmt     *pgconn.StatementDescription
+	interruptCrawlRunsStmt      *pgconn.StatementDescription
+	putMigrationStmt            *pgconn.StatementDescription
+	getArticleUrlsStmt          *pgconn.StatementDescription
+	deleteArticlesByIdStmt      *pgconn.StatementDescription
+	updateArticleUrlStmt        *pgconn.StatementDescription
 }
 
 var (
@@ -48,31 +83,88 @@ func NewDatabase() (*Database, error) {
 	}
 
 	_, err = conn.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS habs(habType text unique, habMainPageUrl text unique);
-	CREATE TABLE IF NOT EXISTS articles (id serial, articleUrl  text, username text, usernameUrl text, title text, date time, habType text references habs(habType));`)
+	CREATE TABLE IF NOT EXISTS articles (id serial, articleUrl  text, username text, usernameUrl text, title text, date timestamptz, habType text references habs(habType));
+	ALTER TABLE habs ADD COLUMN IF NOT EXISTS definition jsonb;
+	ALTER TABLE habs ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'running', ADD COLUMN IF NOT EXISTS scheduleOverride text,
+		ADD COLUMN IF NOT EXISTS lastRun timestamptz, ADD COLUMN IF NOT EXISTS nextRun timestamptz;
+	UPDATE articles SET articleUrl = regexp_replace(split_part(articleUrl, '#', 1), '/+$', '') WHERE articleUrl ~ '(/|#.*)$';
+	DELETE FROM articles a USING articles b WHERE a.articleUrl = b.articleUrl AND a.id > b.id;
+	CREATE UNIQUE INDEX IF NOT EXISTS articles_articleUrl_idx ON articles(articleUrl);
+	ALTER TABLE articles ADD COLUMN IF NOT EXISTS bodyHtml text, ADD COLUMN IF NOT EXISTS bodyText text;
+	CREATE UNIQUE INDEX IF NOT EXISTS articles_id_idx ON articles(id);
+	CREATE TABLE IF NOT EXISTS tags (id serial primary key, name text unique NOT NULL);
+	CREATE TABLE IF NOT EXISTS article_tags (articleId int references articles(id) ON DELETE CASCADE, tagId int references tags(id) ON DELETE CASCADE,
+		primary key (articleId, tagId));
+	ALTER TABLE articles ADD COLUMN IF NOT EXISTS reparseDate boolean NOT NULL DEFAULT false;
+	DO $$ BEGIN
+		IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'articles' AND column_name = 'date') = 'time without time zone' THEN
+			ALTER TABLE articles ALTER COLUMN date TYPE timestamptz USING NULL;
+			UPDATE articles SET reparseDate = true;
+		END IF;
+	END $$;
+	ALTER TABLE articles ADD COLUMN IF NOT EXISTS parsedAt timestamptz NOT NULL DEFAULT now();
+	CREATE TABLE IF NOT EXISTS article_metrics (articleId int references articles(id) ON DELETE CASCADE, rating int, views int, bookmarks int,
+		comments int, collectedAt timestamptz NOT NULL);
+	CREATE INDEX IF NOT EXISTS article_metrics_articleId_idx ON article_metrics(articleId, collectedAt);
+	CREATE TABLE IF NOT EXISTS backfills (habType text primary key references habs(habType), page int NOT NULL, nextPageUrl text NOT NULL,
+		maxPages int NOT NULL, until timestamptz, status text NOT NULL, updatedAt timestamptz NOT NULL);
+	ALTER TABLE articles ADD COLUMN IF NOT EXISTS lastmod timestamptz;
+	ALTER TABLE backfills ADD COLUMN IF NOT EXISTS error text NOT NULL DEFAULT '';
+	CREATE TABLE IF NOT EXISTS sitemaps (url text primary key, habType text references habs(habType), lastmod timestamptz);
+	CREATE TABLE IF NOT EXISTS dead_letters (url text primary key, habType text references habs(habType), reason text NOT NULL,
+		attempts int NOT NULL, failedAt timestamptz NOT NULL);
+	CREATE TABLE IF NOT EXISTS crawl_runs (id text primary key, habType text references habs(habType), trigger text NOT NULL, status text NOT NULL,
+		startedAt timestamptz NOT NULL, finishedAt timestamptz, urls int NOT NULL, queued int NOT NULL, parsed int NOT NULL, failed int NOT NULL,
+		error text NOT NULL DEFAULT '');
+	CREATE INDEX IF NOT EXISTS crawl_runs_habType_idx ON crawl_runs(habType, startedAt);
+	CREATE TABLE IF NOT EXISTS migrations (name text primary key, appliedAt timestamptz NOT NULL);`)
+	if err != nil {
+		logrus.Errorf("failed to create tables, error: %v", err)
+		return nil, err
+	}
 
-	putInArticlesStmt, err := conn.Prepare(context.Background(), "Put Article", `INSERT INTO articles(articleURL, username, usernameURL, title, date, habType) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`)
+	putInArticlesStmt, err := conn.Prepare(context.Background(), "Put Article", `INSERT INTO articles(articleURL, username, usernameURL, title, date, habType, bodyHtml, bodyText, lastmod) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (articleUrl) DO UPDATE SET username = $2, usernameUrl = $3, title = $4, date = $5, habType = $6, bodyHtml = $7, bodyText = $8,
+		lastmod = COALESCE($9, articles.lastmod), reparseDate = false RETURNING id`)
 	if err != nil {
 		logrus.Errorf("failed to prepare putInAriclesStmt, error: %v", err)
 		return nil, err
 	}
 
-	putInformationInHabsStmt, err := conn.Prepare(context.Background(), "Put habs", `INSERT INTO habs(habType, habMainPageUrl) VALUES ($1, $2)`)
+	putInformationInHabsStmt, err := conn.Prepare(context.Background(), "Put habs", `INSERT INTO habs(habType, habMainPageUrl, definition) VALUES ($1, $2, $3)
+		ON CONFLICT (habType) DO UPDATE SET habMainPageUrl = $2, definition = $3, status = 'running', scheduleOverride = NULL, lastRun = NULL, nextRun = NULL`)
 	if err != nil {
 		logrus.Errorf("failed to preapre putInformationInHabsStmt, error: %v", err)
 		return nil, err
 	}
 
+	updateHabDefinitionStmt, err := conn.Prepare(context.Background(), "Update hab definition", `UPDATE habs SET habMainPageUrl = $2, definition = $3,
+		scheduleOverride = CASE WHEN s.changed THEN NULL ELSE scheduleOverride END, nextRun = CASE WHEN s.changed THEN NULL ELSE nextRun END
+		FROM (SELECT (definition->'interval', definition->'cron', definition->'windows')
+			IS DISTINCT FROM ($3::jsonb->'interval', $3::jsonb->'cron', $3::jsonb->'windows') AS changed FROM habs WHERE habType = $1) s
+		WHERE habType = $1 AND definition IS DISTINCT FROM $3::jsonb`)
+	if err != nil {
+		logrus.Errorf("failed to prepare updateHabDefinitionStmt, error: %v", err)
+		return nil, err
+	}
+
+	putHabStateStmt, err := conn.Prepare(context.Background(), "Put hab state", `UPDATE habs SET status = $2, scheduleOverride = $3, lastRun = $4, nextRun = $5 WHERE habType = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putHabStateStmt, error: %v", err)
+		return nil, err
+	}
+
 	getHabInfoStmt, err := conn.Prepare(context.Background(), "Get hab", `SELECT habType FROM habs WHERE habType = $1`)
 	if err != nil {
 		logrus.Errorf("failed to prepare getHabInfoStmt, error: %v", err)
 	}
 
-	getFromHabsInformationStmt, err := conn.Prepare(context.Background(), "Get Hab Information", "SELECT * FROM habs")
+	getFromHabsInformationStmt, err := conn.Prepare(context.Background(), "Get Hab Information", "SELECT habType, habMainPageUrl, definition, status, scheduleOverride, lastRun, nextRun FROM habs")
 	if err != nil {
 		logrus.Errorf("failed to prepare getFromHabsInformationStmt, error: %v", err)
 	}
 
-	deleteHabStmt, err := conn.Prepare(context.Background(), "Delete hab", "DELETE FROM habs WHERE habType = $1 RETURNING habType")
+	deleteHabStmt, err := conn.Prepare(context.Background(), "Delete hab", "UPDATE habs SET status = 'deleted' WHERE habType = $1 RETURNING habType")
 	if err != nil {
 		logrus.Errorf("failed to prepare deleteHabStmt, error: %v", err)
 	}
@@ -82,71 +174,758 @@ func NewDatabase() (*Database, error) {
 		logrus.Errorf("failed to prepare deleteArticlesStmt, error: %v", err)
 	}
 
-	getArticlesStmt, err := conn.Prepare(context.Background(), "Get Articles", `Select * FROM articles`)
+	getArticlesStmt, err := conn.Prepare(context.Background(), "Get Articles", `SELECT id, articleUrl, username, usernameUrl, title, date, habType,
+		CASE WHEN $1 THEN COALESCE(bodyHtml, '') ELSE '' END, CASE WHEN $2 THEN COALESCE(bodyText, '') ELSE '' END,
+		ARRAY(SELECT t.name FROM article_tags at JOIN tags t ON t.id = at.tagId WHERE at.articleId = articles.id ORDER BY t.name)
+		FROM articles WHERE $3 = '' OR id IN (SELECT at.articleId FROM article_tags at JOIN tags t ON t.id = at.tagId WHERE t.name = $3)`)
 	if err != nil {
 		logrus.Errorf("failed to prepare getArticlesStmt, error: %v", err)
 	}
 
+	putTagsStmt, err := conn.Prepare(context.Background(), "Put Tags", `INSERT INTO tags(name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putTagsStmt, error: %v", err)
+		return nil, err
+	}
+
+	deleteArticleTagsStmt, err := conn.Prepare(context.Background(), "Delete Article Tags", `DELETE FROM article_tags WHERE articleId = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare deleteArticleTagsStmt, error: %v", err)
+		return nil, err
+	}
+
+	putArticleTagsStmt, err := conn.Prepare(context.Background(), "Put Article Tags", `INSERT INTO article_tags(articleId, tagId)
+		SELECT $1, id FROM tags WHERE name = ANY($2) ON CONFLICT DO NOTHING`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putArticleTagsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getTagsStmt, err := conn.Prepare(context.Background(), "Get Tags", `SELECT t.name, a.habType, count(*) FROM article_tags at
+		JOIN tags t ON t.id = at.tagId JOIN articles a ON a.id = at.articleId
+		WHERE $1 = '' OR a.habType = $1 GROUP BY t.name, a.habType ORDER BY count(*) DESC, t.name`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getTagsStmt, error: %v", err)
+		return nil, err
+	}
+
+	putArticleMetricsStmt, err := conn.Prepare(context.Background(), "Put Article Metrics", `INSERT INTO article_metrics(articleId, rating, views, bookmarks, comments, collectedAt)
+		VALUES ($1, $2, $3, $4, $5, $6)`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putArticleMetricsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getArticleMetricsStmt, err := conn.Prepare(context.Background(), "Get Article Metrics", `SELECT rating, views, bookmarks, comments, collectedAt
+		FROM article_metrics WHERE articleId = $1 ORDER BY collectedAt`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getArticleMetricsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getArticlesForRevisitStmt, err := conn.Prepare(context.Background(), "Get Articles For Revisit", `SELECT a.id, a.articleUrl, a.habType FROM articles a
+		LEFT JOIN LATERAL (SELECT count(*) AS amount, max(collectedAt) AS last FROM article_metrics m WHERE m.articleId = a.id) m ON true
+		WHERE a.parsedAt > $1 AND a.habType = ANY($2)
+		AND (m.last IS NULL OR m.last + make_interval(secs => $3::float8 * power(2, m.amount - 1)) <= now())
+		ORDER BY m.last NULLS FIRST LIMIT $4`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getArticlesForRevisitStmt, error: %v", err)
+		return nil, err
+	}
+
+	getArticleUrlsToReparseStmt, err := conn.Prepare(context.Background(), "Get Article Urls To Reparse", `SELECT articleUrl FROM articles WHERE habType = $1 AND reparseDate`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getArticleUrlsToReparseStmt, error: %v", err)
+		return nil, err
+	}
+
+	getStoredArticleUrlsStmt, err := conn.Prepare(context.Background(), "Get Stored Article Urls", `SELECT articleUrl FROM articles WHERE articleUrl = ANY($1)`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getStoredArticleUrlsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getLastArticleUrlsStmt, err := conn.Prepare(context.Background(), "Get Last Article Urls", `SELECT articleUrl FROM articles WHERE habType = $1 ORDER BY id DESC LIMIT $2`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getLastArticleUrlsStmt, error: %v", err)
+		return nil, err
+	}
+
+	putBackfillStmt, err := conn.Prepare(context.Background(), "Put Backfill", `INSERT INTO backfills(habType, page, nextPageUrl, maxPages, until, status, updatedAt, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (habType) DO UPDATE SET page = $2, nextPageUrl = $3, maxPages = $4, until = $5, status = $6,
+		updatedAt = $7, error = $8`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putBackfillStmt, error: %v", err)
+		return nil, err
+	}
+
+	getBackfillsStmt, err := conn.Prepare(context.Background(), "Get Backfills", `SELECT habType, page, nextPageUrl, maxPages, until, status, updatedAt, error FROM backfills`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getBackfillsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getArticleLastmodsStmt, err := conn.Prepare(context.Background(), "Get Article Lastmods", `SELECT articleUrl, lastmod FROM articles WHERE articleUrl = ANY($1)`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getArticleLastmodsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getArticleDatesStmt, err := conn.Prepare(context.Background(), "Get Article Dates", `SELECT articleUrl, date FROM articles WHERE articleUrl = ANY($1) AND date IS NOT NULL`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getArticleDatesStmt, error: %v", err)
+		return nil, err
+	}
+
+	getSitemapLastmodStmt, err := conn.Prepare(context.Background(), "Get Sitemap Lastmod", `SELECT lastmod FROM sitemaps WHERE url = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getSitemapLastmodStmt, error: %v", err)
+		return nil, err
+	}
+
+	putSitemapLastmodStmt, err := conn.Prepare(context.Background(), "Put Sitemap Lastmod", `INSERT INTO sitemaps(url, habType, lastmod) VALUES ($1, $2, $3)
+		ON CONFLICT (url) DO UPDATE SET habType = $2, lastmod = $3`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putSitemapLastmodStmt, error: %v", err)
+		return nil, err
+	}
+
+	deleteSitemapsStmt, err := conn.Prepare(context.Background(), "Delete Sitemaps", `DELETE FROM sitemaps WHERE habType = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare deleteSitemapsStmt, error: %v", err)
+		return nil, err
+	}
+
+	putDeadLetterStmt, err := conn.Prepare(context.Background(), "Put Dead Letter", `WITH reparsed AS (UPDATE articles SET reparseDate = false WHERE articleUrl = $1 AND reparseDate)
+		INSERT INTO dead_letters(url, habType, reason, attempts, failedAt)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (url) DO UPDATE SET habType = $2, reason = $3, attempts = $4, failedAt = $5`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putDeadLetterStmt, error: %v", err)
+		return nil, err
+	}
+
+	getDeadLettersStmt, err := conn.Prepare(context.Background(), "Get Dead Letters", `SELECT url, habType, reason, attempts, failedAt FROM dead_letters
+		WHERE $1 = '' OR habType = $1 ORDER BY failedAt DESC`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getDeadLettersStmt, error: %v", err)
+		return nil, err
+	}
+
+	getDeadLetterStmt, err := conn.Prepare(context.Background(), "Get Dead Letter", `SELECT url, habType, reason, attempts, failedAt FROM dead_letters WHERE url = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getDeadLetterStmt, error: %v", err)
+		return nil, err
+	}
+
+	getDeadLetterUrlsStmt, err := conn.Prepare(context.Background(), "Get Dead Letter Urls", `SELECT url FROM dead_letters WHERE url = ANY($1)`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getDeadLetterUrlsStmt, error: %v", err)
+		return nil, err
+	}
+
+	deleteDeadLetterStmt, err := conn.Prepare(context.Background(), "Delete Dead Letter", `DELETE FROM dead_letters WHERE url = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare deleteDeadLetterStmt, error: %v", err)
+		return nil, err
+	}
+
+	deleteHabDeadLettersStmt, err := conn.Prepare(context.Background(), "Delete Hab Dead Letters", `DELETE FROM dead_letters WHERE habType = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare deleteHabDeadLettersStmt, error: %v", err)
+		return nil, err
+	}
+
+	putCrawlRunStmt, err := conn.Prepare(context.Background(), "Put Crawl Run", `INSERT INTO crawl_runs(id, habType, trigger, status, startedAt,
+		finishedAt, urls, queued, parsed, failed, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE SET status = $4, finishedAt = $6, urls = $7, queued = $8, parsed = $9, failed = $10, error = $11`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putCrawlRunStmt, error: %v", err)
+		return nil, err
+	}
+
+	getCrawlRunStmt, err := conn.Prepare(context.Background(), "Get Crawl Run", `SELECT id, habType, trigger, status, startedAt, finishedAt,
+		urls, queued, parsed, failed, error FROM crawl_runs WHERE id = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getCrawlRunStmt, error: %v", err)
+		return nil, err
+	}
+
+	getCrawlRunsStmt, err := conn.Prepare(context.Background(), "Get Crawl Runs", `SELECT id, habType, trigger, status, startedAt, finishedAt,
+		urls, queued, parsed, failed, error FROM crawl_runs WHERE habType = $1 ORDER BY startedAt DESC LIMIT $2`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getCrawlRunsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getCrawlRunStatsStmt, err := conn.Prepare(context.Background(), "Get Crawl Run Stats", `SELECT habType, count(*),
+		count(*) FILTER (WHERE status = 'failed'), sum(urls), sum(queued), sum(parsed), sum(failed)
+		FROM crawl_runs WHERE startedAt >= $1 GROUP BY habType`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getCrawlRunStatsStmt, error: %v", err)
+		return nil, err
+	}
+
+	getDeadLettersCountStmt, err := conn.Prepare(context.Background(), "Get Dead Letters Count", `SELECT habType, count(*) FROM dead_letters GROUP BY habType`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getDeadLettersCountStmt, error: %v", err)
+		return nil, err
+	}
+
+	interruptCrawlRunsStmt, err := conn.Prepare(context.Background(), "Interrupt Crawl Runs", `UPDATE crawl_runs
+		SET status = 'interrupted', finishedAt = $1 WHERE finishedAt IS NULL`)
+	if err != nil {
+		logrus.Errorf("failed to prepare interruptCrawlRunsStmt, error: %v", err)
+		return nil, err
+	}
+
+	putMigrationStmt, err := conn.Prepare(context.Background(), "Put Migration", `INSERT INTO migrations(name, appliedAt) VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING`)
+	if err != nil {
+		logrus.Errorf("failed to prepare putMigrationStmt, error: %v", err)
+		return nil, err
+	}
+
+	getArticleUrlsStmt, err := conn.Prepare(context.Background(), "Get Article Urls", `SELECT id, articleUrl FROM articles ORDER BY id`)
+	if err != nil {
+		logrus.Errorf("failed to prepare getArticleUrlsStmt, error: %v", err)
+		return nil, err
+	}
+
+	deleteArticlesByIdStmt, err := conn.Prepare(context.Background(), "Delete Articles By Id", `DELETE FROM articles WHERE id = ANY($1)`)
+	if err != nil {
+		logrus.Errorf("failed to prepare deleteArticlesByIdStmt, error: %v", err)
+		return nil, err
+	}
+
+	updateArticleUrlStmt, err := conn.Prepare(context.Background(), "Update Article Url", `UPDATE articles SET articleUrl = $2 WHERE id = $1`)
+	if err != nil {
+		logrus.Errorf("failed to prepare updateArticleUrlStmt, error: %v", err)
+		return nil, err
+	}
+
 	return &Database{db: conn,
-		getArticlesStmt:            getArticlesStmt,
-		getHabInfoStmt:             getHabInfoStmt,
-		putInArticlesStmt:          putInArticlesStmt,
-		putInformationInHabsStmt:   putInformationInHabsStmt,
-		getFromHabsInformationStmt: getFromHabsInformationStmt,
-		deleteHabStmt:              deleteHabStmt,
-		deleteArticlesStmt:         deleteArticlesStmt,
-		mx:                         sync.Mutex{},
+		getArticlesStmt:             getArticlesStmt,
+		getStoredArticleUrlsStmt:    getStoredArticleUrlsStmt,
+		getArticleUrlsToReparseStmt: getArticleUrlsToReparseStmt,
+		putTagsStmt:                 putTagsStmt,
+		putArticleMetricsStmt:       putArticleMetricsStmt,
+		getArticleMetricsStmt:       getArticleMetricsStmt,
+		getArticlesForRevisitStmt:   getArticlesForRevisitStmt,
+		deleteArticleTagsStmt:       deleteArticleTagsStmt,
+		putArticleTagsStmt:          putArticleTagsStmt,
+		getTagsStmt:                 getTagsStmt,
+		getLastArticleUrlsStmt:      getLastArticleUrlsStmt,
+		getHabInfoStmt:              getHabInfoStmt,
+		updateHabDefinitionStmt:     updateHabDefinitionStmt,
+		putInArticlesStmt:           putInArticlesStmt,
+		putInformationInHabsStmt:    putInformationInHabsStmt,
+		putHabStateStmt:             putHabStateStmt,
+		getFromHabsInformationStmt:  getFromHabsInformationStmt,
+		deleteHabStmt:               deleteHabStmt,
+		deleteArticlesStmt:          deleteArticlesStmt,
+		putBackfillStmt:             putBackfillStmt,
+		getBackfillsStmt:            getBackfillsStmt,
+		getArticleLastmodsStmt:      getArticleLastmodsStmt,
+		getArticleDatesStmt:         getArticleDatesStmt,
+		getSitemapLastmodStmt:       getSitemapLastmodStmt,
+		putSitemapLastmodStmt:       putSitemapLastmodStmt,
+		deleteSitemapsStmt:          deleteSitemapsStmt,
+		putDeadLetterStmt:           putDeadLetterStmt,
+		getDeadLettersStmt:          getDeadLettersStmt,
+		getDeadLetterStmt:           getDeadLetterStmt,
+		getDeadLetterUrlsStmt:       getDeadLetterUrlsStmt,
+		deleteDeadLetterStmt:        deleteDeadLetterStmt,
+		deleteHabDeadLettersStmt:    deleteHabDeadLettersStmt,
+		putCrawlRunStmt:             putCrawlRunStmt,
+		getCrawlRunStmt:             getCrawlRunStmt,
+		getCrawlRunsStmt:            getCrawlRunsStmt,
+		getCrawlRunStatsStmt:        getCrawlRunStatsStmt,
+		getDeadLettersCountStmt:     getDeadLettersCountStmt,
+		interruptCrawlRunsStmt:      interruptCrawlRunsStmt,
+		putMigrationStmt:            putMigrationStmt,
+		getArticleUrlsStmt:          getArticleUrlsStmt,
+		deleteArticlesByIdStmt:      deleteArticlesByIdStmt,
+		updateArticleUrlStmt:        updateArticleUrlStmt,
+		mx:                          sync.Mutex{},
 	}, nil
 }
 
-func (d *Database) PutArticle(articleUrl string, username string, usernameUrl string, title string, date time.Time, habType string) (int, error) {
+// PutArticle saves article with its tags and metrics snapshot, if article with such url already exists it is updated.
+func (d *Database) PutArticle(article *models.ArticleData) (int, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	tx, err := d.db.Begin(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
 	var id int
-	if err := d.db.QueryRow(context.Background(), d.putInArticlesStmt.Name, articleUrl, username, usernameUrl, title, date, habType).Scan(&id); err != nil {
+	if err = tx.QueryRow(context.Background(), d.putInArticlesStmt.Name, article.Url, article.Username, article.UsernameUrl,
+		article.Title, nullTime(article.PublishData), article.HabType, article.BodyHtml, article.BodyText, nullTime(article.Lastmod)).Scan(&id); err != nil {
+
+		return 0, err
+	}
+
+	_, err = tx.Exec(context.Background(), d.deleteArticleTagsStmt.Name, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(article.Tags) != 0 {
+		_, err = tx.Exec(context.Background(), d.putTagsStmt.Name, article.Tags)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(context.Background(), d.putArticleTagsStmt.Name, id, article.Tags)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if article.Metrics != nil {
+		_, err = tx.Exec(context.Background(), d.putArticleMetricsStmt.Name, id, article.Metrics.Rating, article.Metrics.Views,
+			article.Metrics.Bookmarks, article.Metrics.Comments, article.Metrics.CollectedAt)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit(context.Background())
+}
+
+// PutArticles saves articles in one transaction and returns their ids in the same order.
+// Articles are sent in batches, so saving them takes two round trips regardless of their amount.
+// If any article is not saved, none of them are saved.
+func (d *Database) PutArticles(articles []*models.ArticleData) ([]int, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	tx, err := d.db.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	batch := &pgx.Batch{}
+	for _, article := range articles {
+		batch.Queue(d.putInArticlesStmt.Name, article.Url, article.Username, article.UsernameUrl, article.Title,
+			nullTime(article.PublishData), article.HabType, article.BodyHtml, article.BodyText, nullTime(article.Lastmod))
+	}
+
+	ids := make([]int, len(articles))
+	results := tx.SendBatch(context.Background(), batch)
+	for i := range articles {
+		err = results.QueryRow().Scan(&ids[i])
+		if err != nil {
+			_ = results.Close()
+			return nil, err
+		}
+	}
+
+	err = results.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	batch = &pgx.Batch{}
+	for i, article := range articles {
+		batch.Queue(d.deleteArticleTagsStmt.Name, ids[i])
+
+		if len(article.Tags) != 0 {
+			batch.Queue(d.putTagsStmt.Name, article.Tags)
+			batch.Queue(d.putArticleTagsStmt.Name, ids[i], article.Tags)
+		}
+
+		if article.Metrics != nil {
+			batch.Queue(d.putArticleMetricsStmt.Name, ids[i], article.Metrics.Rating, article.Metrics.Views,
+				article.Metrics.Bookmarks, article.Metrics.Comments, article.Metrics.CollectedAt)
+		}
+	}
+
+	results = tx.SendBatch(context.Background(), batch)
+	for i := 0; i < batch.Len(); i++ {
+		_, err = results.Exec()
+		if err != nil {
+			_ = results.Close()
+			return nil, err
+		}
+	}
+
+	err = results.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, tx.Commit(context.Background())
+}
+
+// PutArticleMetrics saves snapshot of the article metrics.
+func (d *Database) PutArticleMetrics(articleId int, metrics models.ArticleMetrics) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	_, err := d.db.Exec(context.Background(), d.putArticleMetricsStmt.Name, articleId, metrics.Rating, metrics.Views,
+		metrics.Bookmarks, metrics.Comments, metrics.CollectedAt)
+	return err
+}
+
+// GetArticleMetrics returns history of the article metrics ordered by time.
+func (d *Database) GetArticleMetrics(articleId int) ([]models.ArticleMetrics, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getArticleMetricsStmt.Name, articleId)
+	if err != nil {
+		logrus.Errorf("failed to get metrics from database, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := make([]models.ArticleMetrics, 0)
+	for rows.Next() {
+		var m models.ArticleMetrics
+		err = rows.Scan(&m.Rating, &m.Views, &m.Bookmarks, &m.Comments, &m.CollectedAt)
+		if err != nil {
+			logrus.Errorf("failed to scan metrics, error: %v", err)
+			continue
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// GetArticlesForRevisit returns up to limit articles of habTypes parsed after since, which metrics should be collected again.
+// Interval between snapshots starts from firstInterval and doubles after every snapshot.
+// Only id, url and habType of the articles are returned.
+func (d *Database) GetArticlesForRevisit(since time.Time, firstInterval time.Duration, habTypes []string, limit int) ([]models.ArticleData, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getArticlesForRevisitStmt.Name, since, habTypes, firstInterval.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	articles := make([]models.ArticleData, 0)
+	for rows.Next() {
+		var article models.ArticleData
+		err = rows.Scan(&article.Id, &article.Url, &article.HabType)
+		if err != nil {
+			return nil, err
+		}
+
+		articles = append(articles, article)
+	}
+
+	return articles, rows.Err()
+}
+
+// GetTags returns amount of articles with every tag per hab.
+// If habType is not empty, only tags of this hab are returned.
+func (d *Database) GetTags(habType string) ([]models.TagCount, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getTagsStmt.Name, habType)
+	if err != nil {
+		logrus.Errorf("failed to get tags from database, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]models.TagCount, 0)
+	for rows.Next() {
+		var tag models.TagCount
+		err = rows.Scan(&tag.Tag, &tag.HabType, &tag.Count)
+		if err != nil {
+			logrus.Errorf("failed to scan tag, error: %v", err)
+			continue
+		}
+
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetStoredArticleUrls returns those of urls, which are already saved in articles.
+func (d *Database) GetStoredArticleUrls(urls []string) (map[string]struct{}, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getStoredArticleUrlsStmt.Name, urls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[string]struct{})
+	for rows.Next() {
+		var url string
+		err = rows.Scan(&url)
+		if err != nil {
+			return nil, err
+		}
+
+		stored[url] = struct{}{}
+	}
+
+	return stored, rows.Err()
+}
+
+// GetArticleLastmods returns lastmod of those of urls, which are already saved in articles.
+// Lastmod is zero, if it is unknown.
+func (d *Database) GetArticleLastmods(urls []string) (map[string]time.Time, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getArticleLastmodsStmt.Name, urls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastmods := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			url     string
+			lastmod *time.Time
+		)
+
+		err = rows.Scan(&url, &lastmod)
+		if err != nil {
+			return nil, err
+		}
+
+		lastmods[url] = time.Time{}
+		if lastmod != nil {
+			lastmods[url] = *lastmod
+		}
+	}
+
+	return lastmods, rows.Err()
+}
+
+// GetArticleDates returns publish dates of the stored articles with urls. Articles without date are not returned.
+func (d *Database) GetArticleDates(urls []string) (map[string]time.Time, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getArticleDatesStmt.Name, urls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dates := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			url  string
+			date time.Time
+		)
+
+		err = rows.Scan(&url, &date)
+		if err != nil {
+			return nil, err
+		}
+
+		dates[url] = date
+	}
+
+	return dates, rows.Err()
+}
+
+// GetSitemapLastmod returns lastmod of the sitemap, which was saved after its last processing.
+// If sitemap was not processed yet, GetSitemapLastmod returns ErrRowNotExist.
+func (d *Database) GetSitemapLastmod(sitemapUrl string) (time.Time, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	var lastmod *time.Time
+	err := d.db.QueryRow(context.Background(), d.getSitemapLastmodStmt.Name, sitemapUrl).Scan(&lastmod)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, ErrRowNotExist
+		}
+
+		return time.Time{}, err
+	}
+
+	if lastmod == nil {
+		return time.Time{}, nil
+	}
+
+	return *lastmod, nil
+}
+
+// PutSitemapLastmod saves lastmod of the processed sitemap of the hab.
+func (d *Database) PutSitemapLastmod(habType string, sitemapUrl string, lastmod time.Time) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	_, err := d.db.Exec(context.Background(), d.putSitemapLastmodStmt.Name, sitemapUrl, habType, nullTime(lastmod))
+	return err
+}
+
+// GetArticleUrlsToReparse returns urls of the hab articles, which dates were reset by the migration to timestamptz.
+func (d *Database) GetArticleUrlsToReparse(habType string) ([]string, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getArticleUrlsToReparseStmt.Name, habType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]string, 0)
+	for rows.Next() {
+		var url string
+		err = rows.Scan(&url)
+		if err != nil {
+			return nil, err
+		}
+
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+// GetLastArticleUrls returns urls of the last limit articles of the hab.
+func (d *Database) GetLastArticleUrls(habType string, limit int) ([]string, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getLastArticleUrlsStmt.Name, habType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]string, 0)
+	for rows.Next() {
+		var url string
+		err = rows.Scan(&url)
+		if err != nil {
+			return nil, err
+		}
 
-		return 0, err
+		urls = append(urls, url)
 	}
 
-	return id, nil
+	return urls, rows.Err()
 }
 
-func (d *Database) GetArticles() ([]models.ArticleData, error) {
+// GetArticles returns articles matching the filter. Article body is returned only if it is requested
+// in the filter, because it is large.
+func (d *Database) GetArticles(filter models.ArticlesFilter) ([]models.ArticleData, error) {
 	d.mx.Lock()
 	defer d.mx.Unlock()
 
-	rows, err := d.db.Query(context.Background(), d.getArticlesStmt.Name)
+	rows, err := d.db.Query(context.Background(), d.getArticlesStmt.Name, filter.WithHtml, filter.WithText, filter.Tag)
 	if err != nil {
 		logrus.Errorf("failed to get data from database, error: %v", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	articles := make([]models.ArticleData, 0)
 
 	for rows.Next() {
-		var id int
-		var article models.ArticleData
-		err = rows.Scan(&id, &article.Url, &article.Username, &article.UsernameUrl, &article.Title, &article.PublishData, &article.HabType)
+		var (
+			article     models.ArticleData
+			publishData *time.Time
+		)
+
+		err = rows.Scan(&article.Id, &article.Url, &article.Username, &article.UsernameUrl, &article.Title, &publishData, &article.HabType,
+			&article.BodyHtml, &article.BodyText, &article.Tags)
 		if err != nil {
 			logrus.Errorf("failed to scan data, error: %v", err)
 			continue
 		}
 
+		if publishData != nil {
+			article.PublishData = *publishData
+		}
+
 		articles = append(articles, article)
 	}
 
 	return articles, nil
 }
 
-func (d *Database) PutHab(habType string, mainPageUrl string) error {
-	logrus.Infof("put data %s", habType)
-	d.db.QueryRow(context.Background(), d.putInformationInHabsStmt.Name, habType, mainPageUrl)
-	return nil
+// PutHab saves hab definition, if hab already exists its definition is replaced
+// and its state is reset.
+func (d *Database) PutHab(def models.HabDefinition) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rawDef, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("put data %s", def.HabType)
+	_, err = d.db.Exec(context.Background(), d.putInformationInHabsStmt.Name, def.HabType, def.MainPageUrl, rawDef)
+	return err
+}
+
+// UpdateHabDefinition replaces definition of the existing hab, keeping its status and scheduler state.
+// It returns true, if saved definition differs from def and was replaced.
+func (d *Database) UpdateHabDefinition(def models.HabDefinition) (bool, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rawDef, err := json.Marshal(def)
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := d.db.Exec(context.Background(), d.updateHabDefinitionStmt.Name, def.HabType, def.MainPageUrl, rawDef)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() != 0, nil
+}
+
+// PutHabState saves scheduler state of the hab.
+func (d *Database) PutHabState(habType string, state models.HabState) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	var schedule *string
+	if state.Schedule != "" {
+		schedule = &state.Schedule
+	}
+
+	_, err := d.db.Exec(context.Background(), d.putHabStateStmt.Name, habType, state.Status, schedule, nullTime(state.LastRun), nullTime(state.NextRun))
+	return err
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
 }
 
 func (d *Database) GetHabInfo(habType string) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
 	var str string
 	err := d.db.QueryRow(context.Background(), d.getHabInfoStmt.Name, habType).Scan(&str)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return ErrRowNotExist
 		}
 
@@ -156,35 +935,76 @@ func (d *Database) GetHabInfo(habType string) error {
 	return nil
 }
 
+// GetHabsInfo returns all habs from storage.
+// Definition of the hab is nil, if it was not saved.
 func (d *Database) GetHabsInfo() ([]models.HabInfo, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
 	rows, err := d.db.Query(context.Background(), d.getFromHabsInformationStmt.Name)
 	if err != nil {
-		logrus.Errorf("failed to put data in table, error: %v", err)
+		logrus.Errorf("failed to get data from table, error: %v", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	var (
-		habType string
-		mainUrl string
+		habType  string
+		mainUrl  string
+		rawDef   []byte
+		status   string
+		schedule *string
+		lastRun  *time.Time
+		nextRun  *time.Time
 	)
 	habInfo := make([]models.HabInfo, 0)
 
 	for rows.Next() {
-		err = rows.Scan(&habType, &mainUrl)
+		err = rows.Scan(&habType, &mainUrl, &rawDef, &status, &schedule, &lastRun, &nextRun)
 		if err != nil {
 			logrus.Errorf("failed to scan data in %s, error: %v", habType, err)
 			continue
 		}
 
-		habInfo = append(habInfo, models.HabInfo{
+		info := models.HabInfo{
 			HabType:     habType,
 			MainPageUrl: mainUrl,
-		})
+			State: models.HabState{
+				Status: status,
+			},
+		}
+
+		if schedule != nil {
+			info.State.Schedule = *schedule
+		}
+
+		if lastRun != nil {
+			info.State.LastRun = *lastRun
+		}
+
+		if nextRun != nil {
+			info.State.NextRun = *nextRun
+		}
+
+		if rawDef != nil {
+			var def models.HabDefinition
+			err = json.Unmarshal(rawDef, &def)
+			if err != nil {
+				logrus.Errorf("failed to unmarshal definition of %s, error: %v", habType, err)
+				continue
+			}
+
+			info.Definition = &def
+		}
+
+		habInfo = append(habInfo, info)
 	}
 
-	return habInfo, nil
+	return habInfo, rows.Err()
 }
 
+// DeleteHab deletes all articles of the hab and marks hab as deleted,
+// so it is not restored on the next start.
 func (d *Database) DeleteHab(habType string) ([]int, error) {
 	d.mx.Lock()
 	defer d.mx.Unlock()
@@ -214,6 +1034,18 @@ func (d *Database) DeleteHab(habType string) ([]int, error) {
 		ids = append(ids, id)
 	}
 
+	_, err = tx.Exec(context.Background(), d.deleteSitemapsStmt.Name, habType)
+	if err != nil {
+		tx.Rollback(context.Background())
+		return nil, err
+	}
+
+	_, err = tx.Exec(context.Background(), d.deleteHabDeadLettersStmt.Name, habType)
+	if err != nil {
+		tx.Rollback(context.Background())
+		return nil, err
+	}
+
 	var hab string
 	err = tx.QueryRow(context.Background(), d.deleteHabStmt.Name, habType).Scan(&hab)
 	if err != nil {
@@ -229,3 +1061,338 @@ func (d *Database) DeleteHab(habType string) ([]int, error) {
 	}
 	return ids, nil
 }
+
+// Close closes connection to the database.
+func (d *Database) Close(ctx context.Context) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	return d.db.Close(ctx)
+}
+
+// PutCrawlRun saves run of the hab, if it is already saved, its progress is updated.
+func (d *Database) PutCrawlRun(run models.CrawlRun) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	_, err := d.db.Exec(context.Background(), d.putCrawlRunStmt.Name, run.ID, run.HabType, run.Trigger, run.Status, run.StartedAt,
+		run.FinishedAt, run.Urls, run.Queued, run.Parsed, run.Failed, run.Error)
+	return err
+}
+
+// GetCrawlRun returns run with id, if it does not exist, GetCrawlRun returns ErrRowNotExist.
+func (d *Database) GetCrawlRun(id string) (models.CrawlRun, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	run, err := scanCrawlRun(d.db.QueryRow(context.Background(), d.getCrawlRunStmt.Name, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.CrawlRun{}, ErrRowNotExist
+	}
+
+	return run, err
+}
+
+// GetCrawlRuns returns limit latest runs of the hab, the latest are returned first.
+func (d *Database) GetCrawlRuns(habType string, limit int) ([]models.CrawlRun, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getCrawlRunsStmt.Name, habType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make([]models.CrawlRun, 0)
+	for rows.Next() {
+		run, err := scanCrawlRun(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// InterruptCrawlRuns marks runs, which are not finished, as interrupted and returns their amount.
+func (d *Database) InterruptCrawlRuns() (int64, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	tag, err := d.db.Exec(context.Background(), d.interruptCrawlRunsStmt.Name, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// NormalizeArticleUrls replaces urls of the stored articles with normalized by normalize once,
+// the migration is remembered in migrations table. Articles with the same normalized url are merged into the oldest one.
+// NormalizeArticleUrls returns amount of changed and deleted articles, which is zero, if migration is already applied.
+func (d *Database) NormalizeArticleUrls(normalize func(string) string) (int, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	tx, err := d.db.Begin(context.Background())
+	if err != nil {
+		logrus.Errorf("failed to init transaction, error: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	tag, err := tx.Exec(context.Background(), d.putMigrationStmt.Name, "normalize-article-urls", time.Now())
+	if err != nil || tag.RowsAffected() == 0 {
+		return 0, err
+	}
+
+	rows, err := tx.Query(context.Background(), d.getArticleUrlsStmt.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := make(map[string]struct{})
+	duplicates := make([]int, 0)
+	changed := make(map[int]string)
+	for rows.Next() {
+		var (
+			id  int
+			url string
+		)
+
+		err = rows.Scan(&id, &url)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		normalized := normalize(url)
+		if _, ok := kept[normalized]; ok {
+			duplicates = append(duplicates, id)
+			continue
+		}
+
+		kept[normalized] = struct{}{}
+		if normalized != url {
+			changed[id] = normalized
+		}
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// duplicates are deleted first, so that changed urls do not conflict with them
+	_, err = tx.Exec(context.Background(), d.deleteArticlesByIdStmt.Name, duplicates)
+	if err != nil {
+		return 0, err
+	}
+
+	for id, url := range changed {
+		_, err = tx.Exec(context.Background(), d.updateArticleUrlStmt.Name, id, url)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(duplicates) + len(changed), tx.Commit(context.Background())
+}
+
+func scanCrawlRun(row pgx.Row) (models.CrawlRun, error) {
+	var run models.CrawlRun
+	err := row.Scan(&run.ID, &run.HabType, &run.Trigger, &run.Status, &run.StartedAt, &run.FinishedAt,
+		&run.Urls, &run.Queued, &run.Parsed, &run.Failed, &run.Error)
+	return run, err
+}
+
+// GetCrawlRunStats returns totals of the runs started since the given time by habs.
+func (d *Database) GetCrawlRunStats(since time.Time) (map[string]models.HabRunStats, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getCrawlRunStatsStmt.Name, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]models.HabRunStats)
+	for rows.Next() {
+		var habType string
+		var s models.HabRunStats
+		err = rows.Scan(&habType, &s.Runs, &s.FailedRuns, &s.Urls, &s.Queued, &s.Parsed, &s.Failed)
+		if err != nil {
+			return nil, err
+		}
+
+		stats[habType] = s
+	}
+
+	return stats, rows.Err()
+}
+
+// GetDeadLettersCount returns amount of dead letters by habs.
+func (d *Database) GetDeadLettersCount() (map[string]int, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getDeadLettersCountStmt.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var habType string
+		var count int
+		err = rows.Scan(&habType, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[habType] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// PutDeadLetter saves article, which failed to be parsed, if it is already saved, it is replaced.
+func (d *Database) PutDeadLetter(letter models.DeadLetter) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	_, err := d.db.Exec(context.Background(), d.putDeadLetterStmt.Name, letter.Url, letter.HabType, letter.Reason,
+		letter.Attempts, letter.FailedAt)
+	return err
+}
+
+// GetDeadLetters returns dead letters of the hab, or of all habs, if habType is empty. The latest are returned first.
+func (d *Database) GetDeadLetters(habType string) ([]models.DeadLetter, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getDeadLettersStmt.Name, habType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := make([]models.DeadLetter, 0)
+	for rows.Next() {
+		var letter models.DeadLetter
+		err = rows.Scan(&letter.Url, &letter.HabType, &letter.Reason, &letter.Attempts, &letter.FailedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		letters = append(letters, letter)
+	}
+
+	return letters, rows.Err()
+}
+
+// GetDeadLetter returns dead letter with url, if it does not exist, GetDeadLetter returns ErrRowNotExist.
+func (d *Database) GetDeadLetter(url string) (models.DeadLetter, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	var letter models.DeadLetter
+	err := d.db.QueryRow(context.Background(), d.getDeadLetterStmt.Name, url).Scan(&letter.Url, &letter.HabType,
+		&letter.Reason, &letter.Attempts, &letter.FailedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.DeadLetter{}, ErrRowNotExist
+	}
+
+	return letter, err
+}
+
+// GetDeadLetterUrls returns those of urls, which are in dead letters.
+func (d *Database) GetDeadLetterUrls(urls []string) (map[string]struct{}, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getDeadLetterUrlsStmt.Name, urls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := make(map[string]struct{})
+	for rows.Next() {
+		var url string
+		err = rows.Scan(&url)
+		if err != nil {
+			return nil, err
+		}
+
+		letters[url] = struct{}{}
+	}
+
+	return letters, rows.Err()
+}
+
+// DeleteDeadLetter deletes dead letter with url, if it does not exist, DeleteDeadLetter returns ErrRowNotExist.
+func (d *Database) DeleteDeadLetter(url string) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	tag, err := d.db.Exec(context.Background(), d.deleteDeadLetterStmt.Name, url)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRowNotExist
+	}
+
+	return nil
+}
+
+// PutBackfill saves progress of the hab backfill.
+func (d *Database) PutBackfill(state models.BackfillState) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	_, err := d.db.Exec(context.Background(), d.putBackfillStmt.Name, state.HabType, state.Page, state.NextPageUrl,
+		state.MaxPages, nullTime(state.Until), state.Status, state.UpdatedAt, state.Error)
+	return err
+}
+
+// GetBackfills returns progress of all backfills.
+func (d *Database) GetBackfills() ([]models.BackfillState, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	rows, err := d.db.Query(context.Background(), d.getBackfillsStmt.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backfills := make([]models.BackfillState, 0)
+	for rows.Next() {
+		var (
+			state models.BackfillState
+			until *time.Time
+		)
+
+		err = rows.Scan(&state.HabType, &state.Page, &state.NextPageUrl, &state.MaxPages, &until, &state.Status, &state.UpdatedAt, &state.Error)
+		if err != nil {
+			logrus.Errorf("failed to scan backfill, error: %v", err)
+			continue
+		}
+
+		if until != nil {
+			state.Until = *until
+		}
+
+		backfills = append(backfills, state)
+	}
+
+	return backfills, rows.Err()
+}
@@ -6,13 +6,22 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
+	"strings"
 	"testTask/internal/cast"
 	"testTask/internal/database"
+	"testTask/internal/models"
 	"testTask/internal/parser"
 	"testTask/internal/user"
+	"time"
 )
 
-var ErrNoTokenProvided = errors.New("no token provided")
+// defaultRunsLimit is amount of runs returned by /api/v1/habs/{hab}/runs, if limit is not specified.
+const defaultRunsLimit = 20
+
+var (
+	ErrNoTokenProvided = errors.New("no token provided")
+	ErrUnknownField    = errors.New("unknown field, available fields: bodyHtml, bodyText")
+)
 
 var routingMap = map[string]route{
 	"/status": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
@@ -34,16 +43,101 @@ var routingMap = map[string]route{
 	}},
 
 	"/api/v1/hab": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
-		if cast.ByteArrayToSting(ctx.Method()) == fasthttp.MethodDelete {
+		method := cast.ByteArrayToSting(ctx.Method())
+		if method == fasthttp.MethodDelete {
 			handler.deleteHab(ctx)
+		} else if method == fasthttp.MethodPost {
+			handler.registerHab(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+
+	"/api/v1/backfill": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		method := cast.ByteArrayToSting(ctx.Method())
+		if method == fasthttp.MethodPost {
+			handler.startBackfill(ctx)
+		} else if method == fasthttp.MethodGet {
+			handler.getBackfill(ctx)
 		} else {
 			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
 		}
 	}},
 
 	"/api/v1/articles": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
-		if cast.ByteArrayToSting(ctx.Method()) == fasthttp.MethodGet {
+		method := cast.ByteArrayToSting(ctx.Method())
+		if method == fasthttp.MethodGet {
 			handler.getArticles(ctx)
+		} else if method == fasthttp.MethodPost {
+			handler.ingestArticles(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+
+	"/api/v1/metrics": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		if cast.ByteArrayToSting(ctx.Method()) == fasthttp.MethodGet {
+			handler.getArticleMetrics(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+
+	"/api/v1/crawl": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		method := cast.ByteArrayToSting(ctx.Method())
+		if method == fasthttp.MethodPost {
+			handler.crawlHab(ctx)
+		} else if method == fasthttp.MethodGet {
+			handler.getCrawlRun(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+
+	"/api/v1/dead-letters": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		method := cast.ByteArrayToSting(ctx.Method())
+		if method == fasthttp.MethodGet {
+			handler.getDeadLetters(ctx)
+		} else if method == fasthttp.MethodPost {
+			handler.retryDeadLetter(ctx)
+		} else if method == fasthttp.MethodDelete {
+			handler.discardDeadLetter(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+
+	"/api/v1/habs": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		if cast.ByteArrayToSting(ctx.Method()) == fasthttp.MethodGet {
+			handler.getHabs(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+
+	"/api/v1/health": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		if cast.ByteArrayToSting(ctx.Method()) == fasthttp.MethodGet {
+			handler.getHabsHealth(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+
+	"/api/v1/tags": {handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		if cast.ByteArrayToSting(ctx.Method()) == fasthttp.MethodGet {
+			handler.getTags(ctx)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		}
+	}},
+}
+
+// patternRoutes are matched, if there is no route with the same path in routingMap.
+// Path segments like {hab} match any segment, which is available with ctx.UserValue.
+var patternRoutes = []route{
+	{path: "/api/v1/habs/{hab}/runs", handler: func(ctx *fasthttp.RequestCtx, handler *HttpHandler) {
+		if cast.ByteArrayToSting(ctx.Method()) == fasthttp.MethodGet {
+			handler.getHabRuns(ctx)
 		} else {
 			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
 		}
@@ -87,11 +181,46 @@ func (h *HttpHandler) Handle(ctx *fasthttp.RequestCtx) {
 
 	if r, ok := routingMap[cast.ByteArrayToSting(ctx.Path())]; ok {
 		r.handler(ctx, h)
+	} else if r, ok := matchRoute(ctx); ok {
+		r.handler(ctx, h)
 	} else {
 		ctx.SetStatusCode(fasthttp.StatusNotFound)
 	}
 }
 
+// matchRoute returns pattern route matching path of the request and sets values of its segments.
+func matchRoute(ctx *fasthttp.RequestCtx) (route, bool) {
+	segments := strings.Split(cast.ByteArrayToSting(ctx.Path()), "/")
+
+	for _, r := range patternRoutes {
+		patternSegments := strings.Split(r.path, "/")
+		if len(patternSegments) != len(segments) {
+			continue
+		}
+
+		values := make(map[string]string)
+		matched := true
+		for i, pattern := range patternSegments {
+			if strings.HasPrefix(pattern, "{") && strings.HasSuffix(pattern, "}") && segments[i] != "" {
+				values[strings.Trim(pattern, "{}")] = segments[i]
+			} else if pattern != segments[i] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			for key, value := range values {
+				ctx.SetUserValue(key, value)
+			}
+
+			return r, true
+		}
+	}
+
+	return route{}, false
+}
+
 func (h *HttpHandler) stopParseHab(ctx *fasthttp.RequestCtx) {
 	_, err := h.authorizeModification(ctx)
 	if err != nil {
@@ -139,15 +268,24 @@ func (h *HttpHandler) changeIntervalForHab(ctx *fasthttp.RequestCtx) {
 
 	hab := cast.ByteArrayToSting(ctx.QueryArgs().Peek("hab"))
 	interval := cast.ByteArrayToSting(ctx.QueryArgs().Peek("duration"))
+	if interval == "" {
+		interval = cast.ByteArrayToSting(ctx.QueryArgs().Peek("schedule"))
+	}
 
-	err = h.parser.ChangeIntervalForHab(hab, interval)
+	nextRun, err := h.parser.ChangeIntervalForHab(hab, interval)
 	if err != nil {
 		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
 		return
 	}
 
 	ctx.SetStatusCode(fasthttp.StatusOK)
-	ctx.SetBodyString(fmt.Sprintf("successfully change interval parsing for %s, to %s", hab, interval))
+	if nextRun.IsZero() {
+		ctx.SetBodyString(fmt.Sprintf("successfully change interval parsing for %s, to %s, hab is paused", hab, interval))
+		return
+	}
+
+	ctx.SetBodyString(fmt.Sprintf("successfully change interval parsing for %s, to %s, next run at %s",
+		hab, interval, nextRun.Format(time.RFC3339)))
 }
 
 func (h *HttpHandler) deleteHab(ctx *fasthttp.RequestCtx) {
@@ -169,6 +307,219 @@ func (h *HttpHandler) deleteHab(ctx *fasthttp.RequestCtx) {
 	ctx.SetBodyString(fmt.Sprintf("deleted ids: %d", ids))
 }
 
+func (h *HttpHandler) registerHab(ctx *fasthttp.RequestCtx) {
+	_, err := h.authorizeModification(ctx)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+
+	var def models.HabDefinition
+	err = json.Unmarshal(ctx.PostBody(), &def)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	err = h.parser.RegisterHab(def)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprintf("successfully register hab %s", def.HabType))
+}
+
+func (h *HttpHandler) startBackfill(ctx *fasthttp.RequestCtx) {
+	_, err := h.authorizeModification(ctx)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+
+	hab := cast.ByteArrayToSting(ctx.QueryArgs().Peek("hab"))
+
+	if ctx.QueryArgs().GetBool("resume") {
+		err = h.parser.ResumeBackfill(hab)
+		if err != nil {
+			writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetBodyString(fmt.Sprintf("successfully resume backfill of %s", hab))
+		return
+	}
+
+	var pages int
+	if ctx.QueryArgs().Has("pages") {
+		pages, err = ctx.QueryArgs().GetUint("pages")
+		if err != nil {
+			writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	var until time.Time
+	if ctx.QueryArgs().Has("until") {
+		until, err = time.Parse(time.DateOnly, cast.ByteArrayToSting(ctx.QueryArgs().Peek("until")))
+		if err != nil {
+			writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.parser.Backfill(hab, pages, until)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprintf("successfully start backfill of %s", hab))
+}
+
+func (h *HttpHandler) getBackfill(ctx *fasthttp.RequestCtx) {
+	hab := cast.ByteArrayToSting(ctx.QueryArgs().Peek("hab"))
+
+	state, err := h.parser.GetBackfill(hab)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	writeJson(ctx, state)
+}
+
+func (h *HttpHandler) crawlHab(ctx *fasthttp.RequestCtx) {
+	_, err := h.authorizeModification(ctx)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+
+	hab := cast.ByteArrayToSting(ctx.QueryArgs().Peek("hab"))
+
+	id, err := h.parser.CrawlHab(hab)
+	if errors.Is(err, parser.ErrRunIsAlreadyGoing) {
+		writeError(ctx, err.Error(), fasthttp.StatusConflict)
+		return
+	}
+
+	if errors.Is(err, parser.ErrHabIsStopping) {
+		writeError(ctx, err.Error(), fasthttp.StatusServiceUnavailable)
+		return
+	}
+
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	writeJson(ctx, map[string]string{"id": id})
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+}
+
+func (h *HttpHandler) getCrawlRun(ctx *fasthttp.RequestCtx) {
+	id := cast.ByteArrayToSting(ctx.QueryArgs().Peek("id"))
+
+	data, err := h.parser.GetCrawlRun(id)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusNotFound)
+		return
+	}
+
+	writeJson(ctx, data)
+}
+
+func (h *HttpHandler) getHabs(ctx *fasthttp.RequestCtx) {
+	data, err := h.parser.GetHabs()
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	writeJson(ctx, data)
+}
+
+func (h *HttpHandler) getHabRuns(ctx *fasthttp.RequestCtx) {
+	hab, _ := ctx.UserValue("hab").(string)
+
+	limit := defaultRunsLimit
+	if ctx.QueryArgs().Has("limit") {
+		var err error
+		limit, err = ctx.QueryArgs().GetUint("limit")
+		if err != nil {
+			writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := h.parser.GetHabRuns(hab, limit)
+	if errors.Is(err, parser.ErrHabIsNotExist) {
+		writeError(ctx, err.Error(), fasthttp.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	writeJson(ctx, data)
+}
+
+func (h *HttpHandler) getDeadLetters(ctx *fasthttp.RequestCtx) {
+	hab := cast.ByteArrayToSting(ctx.QueryArgs().Peek("hab"))
+
+	data, err := h.parser.GetDeadLetters(hab)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	writeJson(ctx, data)
+}
+
+func (h *HttpHandler) retryDeadLetter(ctx *fasthttp.RequestCtx) {
+	_, err := h.authorizeModification(ctx)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+
+	url := cast.ByteArrayToSting(ctx.QueryArgs().Peek("url"))
+
+	err = h.parser.RetryDeadLetter(url)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprintf("successfully retry %s", url))
+}
+
+func (h *HttpHandler) discardDeadLetter(ctx *fasthttp.RequestCtx) {
+	_, err := h.authorizeModification(ctx)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+
+	url := cast.ByteArrayToSting(ctx.QueryArgs().Peek("url"))
+
+	err = h.parser.DiscardDeadLetter(url)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprintf("successfully discard %s", url))
+}
+
 func (h *HttpHandler) authorizeModification(ctx *fasthttp.RequestCtx) (string, error) {
 	token := ctx.Request.Header.Peek("Private-Token")
 	if len(token) == 0 {
@@ -179,12 +530,108 @@ func (h *HttpHandler) authorizeModification(ctx *fasthttp.RequestCtx) (string, e
 }
 
 func (h *HttpHandler) getArticles(ctx *fasthttp.RequestCtx) {
-	data, err := h.storage.GetArticles()
+	filter := models.ArticlesFilter{
+		Tag: parser.NormalizeTag(cast.ByteArrayToSting(ctx.QueryArgs().Peek("tag"))),
+	}
+
+	if fields := cast.ByteArrayToSting(ctx.QueryArgs().Peek("fields")); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			switch strings.TrimSpace(field) {
+			case "bodyHtml":
+				filter.WithHtml = true
+			case "bodyText":
+				filter.WithText = true
+			default:
+				writeError(ctx, ErrUnknownField.Error(), fasthttp.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	data, err := h.storage.GetArticles(filter)
 	if err != nil {
 		writeError(ctx, err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
 
+	writeJson(ctx, data)
+}
+
+// ingestRequest is a body of the request to add articles by url: single Url or list of Urls.
+// Hab is optional, by default hab is matched by url.
+type ingestRequest struct {
+	Url  string   `json:"url"`
+	Urls []string `json:"urls"`
+	Hab  string   `json:"hab"`
+}
+
+func (h *HttpHandler) ingestArticles(ctx *fasthttp.RequestCtx) {
+	_, err := h.authorizeModification(ctx)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+
+	var request ingestRequest
+	err = json.Unmarshal(ctx.PostBody(), &request)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	if request.Urls != nil {
+		writeJson(ctx, h.parser.IngestArticles(request.Urls, request.Hab))
+		return
+	}
+
+	result := h.parser.IngestArticle(request.Url, request.Hab)
+	writeJson(ctx, result)
+	if len(result.Errors) != 0 {
+		ctx.SetStatusCode(fasthttp.StatusUnprocessableEntity)
+	}
+}
+
+func (h *HttpHandler) getTags(ctx *fasthttp.RequestCtx) {
+	hab := cast.ByteArrayToSting(ctx.QueryArgs().Peek("hab"))
+
+	data, err := h.storage.GetTags(hab)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	writeJson(ctx, data)
+}
+
+func (h *HttpHandler) getHabsHealth(ctx *fasthttp.RequestCtx) {
+	hab := cast.ByteArrayToSting(ctx.QueryArgs().Peek("hab"))
+
+	data, err := h.parser.GetHabsHealth(hab)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	writeJson(ctx, data)
+}
+
+func (h *HttpHandler) getArticleMetrics(ctx *fasthttp.RequestCtx) {
+	id, err := ctx.QueryArgs().GetUint("id")
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	data, err := h.parser.GetArticleMetrics(id)
+	if err != nil {
+		writeError(ctx, err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	writeJson(ctx, data)
+}
+
+func writeJson(ctx *fasthttp.RequestCtx, data any) {
 	rawResp, err := json.Marshal(data)
 	if err != nil {
 		writeError(ctx, err.Error(), fasthttp.StatusInternalServerError)
@@ -194,7 +641,6 @@ func (h *HttpHandler) getArticles(ctx *fasthttp.RequestCtx) {
 	ctx.Response.Header.Set(fasthttp.HeaderContentType, "application/json")
 	ctx.SetBody(rawResp)
 	ctx.SetStatusCode(fasthttp.StatusOK)
-
 }
 
 type errorResponse struct {
@@ -0,0 +1,281 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ModeLive   = "live"
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+var (
+	ErrUnknownMode       = errors.New("unknown fetcher mode, available modes: live, record, replay")
+	ErrFixtureIsNotExist = errors.New("fixture for the request does not exist")
+)
+
+// Fetcher downloads pages for the parser. It is an http.RoundTripper, so it is used as transport of colly collectors.
+type Fetcher interface {
+	RoundTrip(request *http.Request) (*http.Response, error)
+}
+
+// NewFetcher creates fetcher according to fetcher.mode from configuration:
+// live fetcher downloads pages from the internet, record fetcher also saves responses
+// to fetcher.fixtures-dir and replay fetcher serves responses from fetcher.fixtures-dir without network.
+// If fetcher.cache.ttl is positive, responses are also cached in fetcher.cache.dir.
+func NewFetcher() (Fetcher, error) {
+	dir := viper.GetString("fetcher.fixtures-dir")
+
+	var f Fetcher
+	switch mode := viper.GetString("fetcher.mode"); mode {
+	case "", ModeLive:
+		f = NewLive()
+	case ModeRecord:
+		logrus.Infof("responses are recorded to %s", dir)
+		f = NewRecorder(NewLive(), dir)
+	case ModeReplay:
+		logrus.Infof("responses are replayed from %s", dir)
+		f = NewReplayer(dir)
+	default:
+		return nil, ErrUnknownMode
+	}
+
+	if ttl := viper.GetDuration("fetcher.cache.ttl"); ttl > 0 {
+		cacheDir := viper.GetString("fetcher.cache.dir")
+		logrus.Infof("responses are cached in %s for %s", cacheDir, ttl)
+		f = NewCache(f, cacheDir, ttl)
+	}
+
+	return f, nil
+}
+
+type proxyKey struct{}
+
+// WithProxy returns context of the request, which must be sent through proxy.
+func WithProxy(ctx context.Context, proxy *url.URL) context.Context {
+	return context.WithValue(ctx, proxyKey{}, proxy)
+}
+
+// Live downloads pages from the internet. Requests are sent through proxy from their context,
+// requests without it use proxy from environment.
+type Live struct {
+	transport *http.Transport
+}
+
+func NewLive() *Live {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(request *http.Request) (*url.URL, error) {
+		if proxy, ok := request.Context().Value(proxyKey{}).(*url.URL); ok {
+			return proxy, nil
+		}
+
+		return http.ProxyFromEnvironment(request)
+	}
+
+	return &Live{
+		transport: transport,
+	}
+}
+
+func (l *Live) RoundTrip(request *http.Request) (*http.Response, error) {
+	return l.transport.RoundTrip(request)
+}
+
+// Recorder downloads pages with next fetcher and saves responses to dir, so they can be served by Replayer.
+type Recorder struct {
+	next Fetcher
+	dir  string
+}
+
+func NewRecorder(next Fetcher, dir string) *Recorder {
+	return &Recorder{
+		next: next,
+		dir:  dir,
+	}
+}
+
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := r.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	err = saveFixture(r.dir, request, response, body)
+	if err != nil {
+		logrus.Errorf("failed to record response, URL: %s, error: %v", request.URL, err)
+	}
+
+	return response, nil
+}
+
+// Replayer serves responses saved by Recorder. If response for the request was not recorded,
+// Replayer returns ErrFixtureIsNotExist.
+type Replayer struct {
+	dir string
+}
+
+func NewReplayer(dir string) *Replayer {
+	return &Replayer{
+		dir: dir,
+	}
+}
+
+func (r *Replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	return loadFixture(r.dir, request)
+}
+
+// Cache serves successful responses of GET requests from dir, while they are younger than ttl,
+// other requests are sent to next fetcher. It is intended for development, so that repeated runs do not hit the sites.
+type Cache struct {
+	next Fetcher
+	dir  string
+	ttl  time.Duration
+}
+
+func NewCache(next Fetcher, dir string, ttl time.Duration) *Cache {
+	return &Cache{
+		next: next,
+		dir:  dir,
+		ttl:  ttl,
+	}
+}
+
+func (c *Cache) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method != http.MethodGet {
+		return c.next.RoundTrip(request)
+	}
+
+	metaPath, _ := fixturePaths(c.dir, request)
+	if info, err := os.Stat(metaPath); err == nil && time.Since(info.ModTime()) < c.ttl {
+		response, err := loadFixture(c.dir, request)
+		if err == nil {
+			return response, nil
+		}
+
+		logrus.Errorf("failed to load cached response, URL: %s, error: %v", request.URL, err)
+	}
+
+	response, err := c.next.RoundTrip(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return response, err
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	err = saveFixture(c.dir, request, response, body)
+	if err != nil {
+		logrus.Errorf("failed to cache response, URL: %s, error: %v", request.URL, err)
+	}
+
+	return response, nil
+}
+
+// loadFixture reads response saved by saveFixture, if it does not exist, loadFixture returns ErrFixtureIsNotExist.
+func loadFixture(dir string, request *http.Request) (*http.Response, error) {
+	metaPath, bodyPath := fixturePaths(dir, request)
+
+	rawMeta, err := os.ReadFile(metaPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrFixtureIsNotExist
+		}
+
+		return nil, err
+	}
+
+	var meta fixtureMeta
+	err = json.Unmarshal(rawMeta, &meta)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", meta.StatusCode, http.StatusText(meta.StatusCode)),
+		StatusCode:    meta.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        meta.Header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}, nil
+}
+
+// fixtureMeta is saved next to the response body, so fixtures can be edited by hand.
+type fixtureMeta struct {
+	Method     string      `json:"method"`
+	Url        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+}
+
+func saveFixture(dir string, request *http.Request, response *http.Response, body []byte) error {
+	metaPath, bodyPath := fixturePaths(dir, request)
+
+	err := os.MkdirAll(filepath.Dir(metaPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	rawMeta, err := json.MarshalIndent(fixtureMeta{
+		Method:     request.Method,
+		Url:        request.URL.String(),
+		StatusCode: response.StatusCode,
+		Header:     response.Header,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(bodyPath, body, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(metaPath, rawMeta, 0644)
+}
+
+// fixturePaths returns paths of the meta and body files of the request fixture.
+// Fixtures are grouped by host and named by hash of the method and url.
+func fixturePaths(dir string, request *http.Request) (string, string) {
+	sum := sha1.Sum([]byte(request.Method + " " + request.URL.String()))
+	name := filepath.Join(dir, strings.ReplaceAll(request.URL.Host, ":", "_"), hex.EncodeToString(sum[:]))
+
+	return name + ".json", name + ".body"
+}
@@ -3,15 +3,299 @@ package models
 import "time"
 
 type ArticleData struct {
+	Id          int       `json:"id"`
 	Username    string    `json:"username"`
 	UsernameUrl string    `json:"usernameUrl"`
 	Title       string    `json:"title"`
 	Url         string    `json:"url"`
 	PublishData time.Time `json:"publishData"`
 	HabType     string    `json:"habType"`
+	BodyHtml    string    `json:"bodyHtml,omitempty"`
+	BodyText    string    `json:"bodyText,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+
+	Metrics *ArticleMetrics `json:"-"`
+	Lastmod time.Time       `json:"-"`
+}
+
+// ArticleMetrics is a snapshot of the article engagement metrics.
+type ArticleMetrics struct {
+	Rating      int       `json:"rating"`
+	Views       int       `json:"views"`
+	Bookmarks   int       `json:"bookmarks"`
+	Comments    int       `json:"comments"`
+	CollectedAt time.Time `json:"collectedAt"`
+}
+
+// ArticlesFilter specifies which articles and fields are returned from storage.
+// If Tag is empty, articles are not filtered by tag.
+type ArticlesFilter struct {
+	Tag      string
+	WithHtml bool
+	WithText bool
+}
+
+type TagCount struct {
+	Tag     string `json:"tag"`
+	HabType string `json:"habType"`
+	Count   int    `json:"count"`
 }
 
 type HabInfo struct {
 	HabType     string
 	MainPageUrl string
+	Definition  *HabDefinition
+	State       HabState
+}
+
+const (
+	HabStatusRunning = "running"
+	HabStatusPaused  = "paused"
+	HabStatusDeleted = "deleted"
+)
+
+// HabState is a scheduler state of the hab.
+// Schedule is set only if it was changed through API, empty Schedule means that schedule from hab definition is used.
+type HabState struct {
+	Status   string
+	Schedule string
+	LastRun  time.Time
+	NextRun  time.Time
+}
+
+const (
+	HabSourceHtml    = "html"
+	HabSourceFeed    = "feed"
+	HabSourceSitemap = "sitemap"
+)
+
+// HabDefinition describes how to parse a hab: where to find article links
+// and how to extract article fields from the article page.
+// If Source is feed, MainPageUrl is an RSS or Atom feed url and articles are filled from feed items,
+// with FeedFallback missing fields are taken from the article page.
+// If Source is sitemap, MainPageUrl is a sitemap or sitemap index url, article urls are taken from it
+// and filtered with SitemapPattern regexp, if it is specified.
+// ArticlePattern is a regexp of article urls, which is used to find hab of the article added by url,
+// if it is not specified, SitemapPattern is used.
+// Hab is parsed every Interval, at times matching Cron expression or with intervals of the time Windows,
+// only one of them can be specified. If none of them is specified, parser.default-interval is used.
+type HabDefinition struct {
+	HabType        string       `json:"habType" mapstructure:"hab-type"`
+	Source         string       `json:"source,omitempty" mapstructure:"source"`
+	FeedFallback   bool         `json:"feedFallback,omitempty" mapstructure:"feed-fallback"`
+	MainPageUrl    string       `json:"mainPageUrl" mapstructure:"main-page-url"`
+	BaseUrl        string       `json:"baseUrl" mapstructure:"base-url"`
+	LinkSelector   string       `json:"linkSelector" mapstructure:"link-selector"`
+	SitemapPattern string       `json:"sitemapPattern,omitempty" mapstructure:"sitemap-pattern"`
+	ArticlePattern string       `json:"articlePattern,omitempty" mapstructure:"article-pattern"`
+	Fields         HabFields    `json:"fields" mapstructure:"fields"`
+	Pagination     Pagination   `json:"pagination" mapstructure:"pagination"`
+	Metrics        HabMetrics   `json:"metrics" mapstructure:"metrics"`
+	Politeness     Politeness   `json:"politeness" mapstructure:"politeness"`
+	Client         Client       `json:"client" mapstructure:"client"`
+	Interval       string       `json:"interval,omitempty" mapstructure:"interval"`
+	Cron           string       `json:"cron,omitempty" mapstructure:"cron"`
+	Windows        []TimeWindow `json:"windows,omitempty" mapstructure:"windows"`
+}
+
+// TimeWindow is a period of the day from From till To in the hh:mm form, during which hab is parsed every Interval.
+// Window ends on the next day, if To is not after From. Days is a list of week days in cron format,
+// like mon-fri or sat,sun, if it is empty, window is active every day.
+type TimeWindow struct {
+	Days     string `json:"days,omitempty" mapstructure:"days"`
+	From     string `json:"from" mapstructure:"from"`
+	To       string `json:"to" mapstructure:"to"`
+	Interval string `json:"interval" mapstructure:"interval"`
+}
+
+// Pagination describes how to get next listing page of the hab: with NextSelector,
+// pointing to the link on the next page, or with UrlTemplate like /page{n}/.
+// If MaxPages is not specified, only main page is parsed.
+type Pagination struct {
+	NextSelector string `json:"nextSelector,omitempty" mapstructure:"next-selector"`
+	UrlTemplate  string `json:"urlTemplate,omitempty" mapstructure:"url-template"`
+	MaxPages     int    `json:"maxPages,omitempty" mapstructure:"max-pages"`
+}
+
+// Politeness limits requests to the domains of the hab: Parallelism is a maximum amount of concurrent requests,
+// Delay with random addition up to RandomDelay is a pause after every request, and with ObeyRobots
+// pages disallowed by robots.txt are not downloaded. Not specified settings are taken from parser.politeness.
+type Politeness struct {
+	Parallelism int    `json:"parallelism,omitempty" mapstructure:"parallelism"`
+	Delay       string `json:"delay,omitempty" mapstructure:"delay"`
+	RandomDelay string `json:"randomDelay,omitempty" mapstructure:"random-delay"`
+	ObeyRobots  *bool  `json:"obeyRobots,omitempty" mapstructure:"obey-robots"`
+}
+
+const (
+	ProxyRotationRoundRobin = "round-robin"
+	ProxyRotationRandom     = "random"
+)
+
+// Client describes HTTP client of the hab: UserAgent or list of UserAgents, extra Headers and Cookies
+// in the name=value form, request Timeout, MaxBodySize in bytes and list of HTTP or SOCKS5 Proxies.
+// User agents and proxies are rotated according to ProxyRotation: round-robin or random.
+// Not specified settings are taken from parser.client, headers and cookies are added to the global ones.
+type Client struct {
+	UserAgent     string            `json:"userAgent,omitempty" mapstructure:"user-agent"`
+	UserAgents    []string          `json:"userAgents,omitempty" mapstructure:"user-agents"`
+	Headers       map[string]string `json:"headers,omitempty" mapstructure:"headers"`
+	Cookies       []string          `json:"cookies,omitempty" mapstructure:"cookies"`
+	Timeout       string            `json:"timeout,omitempty" mapstructure:"timeout"`
+	MaxBodySize   int               `json:"maxBodySize,omitempty" mapstructure:"max-body-size"`
+	Proxies       []string          `json:"proxies,omitempty" mapstructure:"proxies"`
+	ProxyRotation string            `json:"proxyRotation,omitempty" mapstructure:"proxy-rotation"`
+}
+
+type HabFields struct {
+	Title       FieldSelector `json:"title" mapstructure:"title"`
+	Username    FieldSelector `json:"username" mapstructure:"username"`
+	UsernameUrl FieldSelector `json:"usernameUrl" mapstructure:"username-url"`
+	PublishDate FieldSelector `json:"publishDate" mapstructure:"publish-date"`
+	Body        FieldSelector `json:"body" mapstructure:"body"`
+	Tags        FieldSelector `json:"tags" mapstructure:"tags"`
+}
+
+// HabMetrics contains selectors of the article engagement metrics.
+// Metrics are collected only if at least one selector is specified.
+type HabMetrics struct {
+	Rating    FieldSelector `json:"rating" mapstructure:"rating"`
+	Views     FieldSelector `json:"views" mapstructure:"views"`
+	Bookmarks FieldSelector `json:"bookmarks" mapstructure:"bookmarks"`
+	Comments  FieldSelector `json:"comments" mapstructure:"comments"`
+}
+
+func (m HabMetrics) IsEmpty() bool {
+	return m.Rating.IsEmpty() && m.Views.IsEmpty() && m.Bookmarks.IsEmpty() && m.Comments.IsEmpty()
+}
+
+// FieldSelector points to the element holding field value with CSS Selector or with XPath expression.
+// If Attr is empty, text of the element is used. If Regex is specified, value is replaced with its first
+// capture group, or with the whole match, if regex has no groups. After that spaces and characters from Trim are trimmed.
+// Layout and Timezone are used only for dates.
+type FieldSelector struct {
+	Selector string `json:"selector,omitempty" mapstructure:"selector"`
+	XPath    string `json:"xpath,omitempty" mapstructure:"xpath"`
+	Attr     string `json:"attr,omitempty" mapstructure:"attr"`
+	Regex    string `json:"regex,omitempty" mapstructure:"regex"`
+	Trim     string `json:"trim,omitempty" mapstructure:"trim"`
+	Layout   string `json:"layout,omitempty" mapstructure:"layout"`
+	Timezone string `json:"timezone,omitempty" mapstructure:"timezone"`
+}
+
+func (f FieldSelector) IsEmpty() bool {
+	return f.Selector == "" && f.XPath == ""
+}
+
+const (
+	HabHealthUnknown  = "unknown"
+	HabHealthHealthy  = "healthy"
+	HabHealthDegraded = "degraded"
+)
+
+// HabHealth is a parse health of the hab: amount of article urls found in the recent runs
+// and share of the recent articles, from which every expected field was extracted.
+type HabHealth struct {
+	HabType     string             `json:"habType"`
+	Status      string             `json:"status"`
+	Reasons     []string           `json:"reasons,omitempty"`
+	LastRunUrls int                `json:"lastRunUrls"`
+	RecentRuns  []int              `json:"recentRuns"`
+	Articles    int                `json:"articles"`
+	FieldRates  map[string]float64 `json:"fieldRates"`
+	CheckedAt   time.Time          `json:"checkedAt"`
+}
+
+const (
+	CrawlTriggerSchedule = "schedule"
+	CrawlTriggerManual   = "manual"
+)
+
+const (
+	CrawlRunStatusRunning     = "running"
+	CrawlRunStatusParsing     = "parsing"
+	CrawlRunStatusDone        = "done"
+	CrawlRunStatusNotModified = "not-modified"
+	CrawlRunStatusFailed      = "failed"
+	CrawlRunStatusInterrupted = "interrupted"
+)
+
+// CrawlRun is a progress of the main page parse of the hab. Status is running, while main page is parsed,
+// parsing, while found articles are parsed, and done, not-modified or failed, when run is finished.
+// Run, which is not finished before the parser is stopped, is interrupted.
+// Urls is amount of article urls found on the main page, Queued of them were new and sent to parse,
+// Parsed were parsed and Failed were moved to dead letters.
+type CrawlRun struct {
+	ID         string     `json:"id"`
+	HabType    string     `json:"habType"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Urls       int        `json:"urls"`
+	Queued     int        `json:"queued"`
+	Parsed     int        `json:"parsed"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// HabSummary is a state of the hab: Status is running, paused or deleted, Crawling is true, while main page
+// of the hab is parsed. LastCrawl is the latest run, Last24h are totals of the runs started during the last day
+// and DeadLetters is amount of articles, which failed to be parsed.
+type HabSummary struct {
+	HabType     string      `json:"habType"`
+	Status      string      `json:"status"`
+	Schedule    string      `json:"schedule"`
+	Crawling    bool        `json:"crawling"`
+	LastRun     *time.Time  `json:"lastRun,omitempty"`
+	NextRun     *time.Time  `json:"nextRun,omitempty"`
+	LastCrawl   *CrawlRun   `json:"lastCrawl,omitempty"`
+	Last24h     HabRunStats `json:"last24h"`
+	DeadLetters int         `json:"deadLetters"`
+}
+
+// HabRunStats are totals of the hab runs, FailedRuns is amount of runs, which main page was not parsed.
+type HabRunStats struct {
+	Runs       int `json:"runs"`
+	FailedRuns int `json:"failedRuns"`
+	Urls       int `json:"urls"`
+	Queued     int `json:"queued"`
+	Parsed     int `json:"parsed"`
+	Failed     int `json:"failed"`
+}
+
+// IngestResult is a result of adding article by url: stored Article or Errors, if it was not stored.
+type IngestResult struct {
+	Url     string       `json:"url"`
+	Article *ArticleData `json:"article,omitempty"`
+	Errors  []string     `json:"errors,omitempty"`
+}
+
+// DeadLetter is an article, which failed to be parsed after all attempts, Reason is the last error.
+type DeadLetter struct {
+	Url      string    `json:"url"`
+	HabType  string    `json:"habType"`
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+const (
+	BackfillStatusRunning = "running"
+	BackfillStatusDone    = "done"
+	BackfillStatusFailed  = "failed"
+)
+
+// BackfillState is a progress of the hab backfill.
+// Page is a number of the next listing page to parse, NextPageUrl is its url.
+// Zero MaxPages means that pages are not limited, zero Until means that dates are not limited.
+type BackfillState struct {
+	HabType     string    `json:"habType"`
+	Page        int       `json:"page"`
+	NextPageUrl string    `json:"nextPageUrl"`
+	MaxPages    int       `json:"maxPages"`
+	Until       time.Time `json:"until"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
 }
@@ -0,0 +1,276 @@
+package parser
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"testTask/internal/models"
+	"time"
+)
+
+// Backfill starts parsing archive of the hab: listing pages are walked from the main page to the older ones,
+// until maxPages pages are parsed, listing page has no articles or articles published before until are reached.
+// Zero maxPages or zero until means that the corresponding limit is not used, but one of them must be specified.
+// Progress is saved in storage after every page, so backfill is resumed after restart.
+// If backfill of the hab is already running, Backfill returns an error.
+// Habs with sitemap source are always parsed fully, so backfill is not supported for them,
+// as well as for habs without pagination.
+func (p *Parser) Backfill(habType string, maxPages int, until time.Time) error {
+	if maxPages < 0 {
+		return ErrMaxPagesIsNegative
+	}
+
+	if maxPages == 0 && until.IsZero() {
+		return ErrBackfillLimitIsNotSpecified
+	}
+
+	h, ok := p.getHab(habType)
+	if !ok {
+		return ErrHabIsNotExist
+	}
+
+	if h.parseFunctions.source == models.HabSourceSitemap {
+		return ErrBackfillIsNotSupported
+	}
+
+	if !h.parseFunctions.paginated {
+		return ErrPaginationIsNotSpecified
+	}
+
+	state := models.BackfillState{
+		HabType:     habType,
+		Page:        1,
+		NextPageUrl: h.parseFunctions.habMainPageUrl,
+		MaxPages:    maxPages,
+		Until:       until,
+		Status:      models.BackfillStatusRunning,
+		UpdatedAt:   time.Now(),
+	}
+
+	return p.startBackfill(h, state)
+}
+
+// ResumeBackfill continues failed backfill of the hab from the page, which failed to be downloaded.
+// If backfill of the hab does not exist or is not failed, ResumeBackfill returns an error.
+func (p *Parser) ResumeBackfill(habType string) error {
+	h, ok := p.getHab(habType)
+	if !ok {
+		return ErrHabIsNotExist
+	}
+
+	state, err := p.GetBackfill(habType)
+	if err != nil {
+		return err
+	}
+
+	if state.Status != models.BackfillStatusFailed {
+		return ErrBackfillIsNotFailed
+	}
+
+	state.Status = models.BackfillStatusRunning
+	state.Error = ""
+	state.UpdatedAt = time.Now()
+
+	logrus.Infof("resume failed backfill of %s from page %d", habType, state.Page)
+	return p.startBackfill(h, state)
+}
+
+// GetBackfill returns progress of the hab backfill.
+func (p *Parser) GetBackfill(habType string) (models.BackfillState, error) {
+	backfills, err := p.storage.GetBackfills()
+	if err != nil {
+		return models.BackfillState{}, err
+	}
+
+	for _, state := range backfills {
+		if state.HabType == habType {
+			return state, nil
+		}
+	}
+
+	return models.BackfillState{}, ErrBackfillIsNotExist
+}
+
+// resumeBackfills starts backfills, which were running before restart.
+func (p *Parser) resumeBackfills() {
+	backfills, err := p.storage.GetBackfills()
+	if err != nil {
+		logrus.Errorf("failed to get backfills, error: %v", err)
+		return
+	}
+
+	for _, state := range backfills {
+		if state.Status != models.BackfillStatusRunning {
+			continue
+		}
+
+		h, ok := p.getHab(state.HabType)
+		if !ok {
+			continue
+		}
+
+		logrus.Infof("resume backfill of %s from page %d", state.HabType, state.Page)
+		err = p.startBackfill(h, state)
+		if err != nil {
+			logrus.Errorf("failed to resume backfill of %s, error: %v", state.HabType, err)
+		}
+	}
+}
+
+func (p *Parser) startBackfill(h *hab, state models.BackfillState) error {
+	p.mx.Lock()
+	if _, ok := p.backfills[state.HabType]; ok {
+		p.mx.Unlock()
+		return ErrBackfillIsAlreadyRunning
+	}
+	p.backfills[state.HabType] = struct{}{}
+	p.mx.Unlock()
+
+	err := p.storage.PutBackfill(state)
+	if err != nil {
+		p.mx.Lock()
+		delete(p.backfills, state.HabType)
+		p.mx.Unlock()
+		return err
+	}
+
+	go func() {
+		p.runBackfill(h, state)
+
+		p.mx.Lock()
+		delete(p.backfills, state.HabType)
+		p.mx.Unlock()
+	}()
+
+	return nil
+}
+
+// runBackfill parses listing pages one by one. New articles of the page are sent to processing routines,
+// and the next page is parsed only after all of them are parsed and parser.backfill-page-delay is passed.
+// If listing page is not downloaded, it is downloaded again with the same backoff as articles,
+// after parser.retry.max-attempts failed attempts backfill is saved as failed and can be resumed with ResumeBackfill.
+func (p *Parser) runBackfill(h *hab, state models.BackfillState) {
+	delay := viper.GetDuration("parser.backfill-page-delay")
+
+	var attempt int
+	for (state.MaxPages == 0 || state.Page <= state.MaxPages) && state.NextPageUrl != "" {
+		logrus.Infof("backfill %s, page: %d", state.HabType, state.Page)
+
+		links, nextPageUrl, err := h.parseFunctions.parseMainPage(state.NextPageUrl, state.Page, nil, visitPage)
+		if err != nil {
+			attempt++
+			if attempt >= viper.GetInt("parser.retry.max-attempts") {
+				logrus.Errorf("failed to parse listing page of %s, stop backfill, page: %d, error: %v", state.HabType, state.Page, err)
+				state.Status = models.BackfillStatusFailed
+				state.Error = err.Error()
+				state.UpdatedAt = time.Now()
+				err = p.storage.PutBackfill(state)
+				if err != nil {
+					logrus.Errorf("failed to save backfill of %s, error: %v", state.HabType, err)
+				}
+
+				return
+			}
+
+			retry := retryDelay(attempt)
+			logrus.Warnf("failed to parse listing page of %s, retry in %s, page: %d, error: %v", state.HabType, retry, state.Page, err)
+			select {
+			case <-time.After(retry):
+				continue
+			case <-h.ctx.Done():
+				return
+			}
+		}
+
+		attempt = 0
+		if len(links) == 0 {
+			logrus.Infof("listing page of %s has no articles, page: %d", state.HabType, state.Page)
+			break
+		}
+
+		state.NextPageUrl = nextPageUrl
+		fresh, _ := h.newArticles(links)
+		reachedUntil := h.storedBefore(links, fresh, state.Until)
+
+		parsed := make(chan *models.ArticleData, len(fresh))
+		for _, elem := range fresh {
+			select {
+			case h.c <- articleInfo{url: elem.url, item: elem.item, habType: h.habType, parsed: parsed}:
+			case <-h.ctx.Done():
+				return
+			}
+		}
+
+		for range fresh {
+			select {
+			case article := <-parsed:
+				if !state.Until.IsZero() && !article.PublishData.IsZero() && article.PublishData.Before(state.Until) {
+					reachedUntil = true
+				}
+
+			case <-h.ctx.Done():
+				return
+			}
+		}
+
+		state.Page++
+		state.UpdatedAt = time.Now()
+		if reachedUntil {
+			break
+		}
+
+		err = p.storage.PutBackfill(state)
+		if err != nil {
+			logrus.Errorf("failed to save backfill of %s, error: %v", state.HabType, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-h.ctx.Done():
+			return
+		}
+	}
+
+	logrus.Infof("backfill of %s is done on page %d", state.HabType, state.Page-1)
+	state.Status = models.BackfillStatusDone
+	err := p.storage.PutBackfill(state)
+	if err != nil {
+		logrus.Errorf("failed to save backfill of %s, error: %v", state.HabType, err)
+	}
+}
+
+// storedBefore returns true, if some of links, which are not fresh, are stored with publish date before until.
+func (h *hab) storedBefore(links []articleLink, fresh []articleLink, until time.Time) bool {
+	if until.IsZero() {
+		return false
+	}
+
+	isFresh := make(map[string]struct{}, len(fresh))
+	for _, elem := range fresh {
+		isFresh[elem.url] = struct{}{}
+	}
+
+	urls := make([]string, 0, len(links))
+	for _, elem := range links {
+		if _, ok := isFresh[elem.url]; !ok {
+			urls = append(urls, elem.url)
+		}
+	}
+
+	if len(urls) == 0 {
+		return false
+	}
+
+	dates, err := h.storage.GetArticleDates(urls)
+	if err != nil {
+		logrus.Errorf("failed to get dates of stored articles of %s, error: %v", h.habType, err)
+		return false
+	}
+
+	for _, date := range dates {
+		if date.Before(until) {
+			return true
+		}
+	}
+
+	return false
+}
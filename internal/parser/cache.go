@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"container/list"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// urlCache is a bounded set of article urls.
+// When cache is full, the least recently used url is evicted.
+type urlCache struct {
+	mx    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+const defaultUrlCacheSize = 10000
+
+func newUrlCache(size int) *urlCache {
+	if size <= 0 {
+		size = defaultUrlCacheSize
+	}
+
+	return &urlCache{
+		size:  size,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+func (c *urlCache) contains(url string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	elem, ok := c.items[url]
+	if ok {
+		c.order.MoveToFront(elem)
+	}
+
+	return ok
+}
+
+func (c *urlCache) add(url string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if elem, ok := c.items[url]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[url] = c.order.PushFront(url)
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(string))
+	}
+}
+
+// normalizeUrl brings article url to the form, in which it is stored:
+// scheme and host are lowercased, fragment, utm parameters and trailing slash are removed.
+func normalizeUrl(rawUrl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return rawUrl
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"context"
+	"github.com/gocolly/colly/v2"
+	"github.com/spf13/viper"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testTask/internal/fetcher"
+	"testTask/internal/models"
+	"time"
+)
+
+const (
+	// habHeader marks requests of the hab, so that transport of the collectors can apply its timeout and proxy.
+	// It is removed before request is sent.
+	habHeader = "X-Parser-Hab"
+	// defaultTimeout is used, if timeout is specified neither for the hab, nor in parser.client.
+	defaultTimeout = 10 * time.Second
+)
+
+// client is a resolved HTTP client settings of the hab.
+type client struct {
+	userAgents  []string
+	headers     map[string]string
+	cookie      string
+	timeout     time.Duration
+	maxBodySize int
+	proxies     []*url.URL
+	rotation    string
+
+	userAgentsCounter atomic.Uint64
+	proxiesCounter    atomic.Uint64
+}
+
+// habClient returns HTTP client settings of the hab, not specified settings are taken from parser.client.
+// Headers and cookies of the hab are added to the global ones.
+func habClient(def models.HabDefinition) (*client, error) {
+	settings := models.Client{
+		UserAgent:     viper.GetString("parser.client.user-agent"),
+		UserAgents:    viper.GetStringSlice("parser.client.user-agents"),
+		Headers:       viper.GetStringMapString("parser.client.headers"),
+		Cookies:       viper.GetStringSlice("parser.client.cookies"),
+		Timeout:       viper.GetString("parser.client.timeout"),
+		MaxBodySize:   viper.GetInt("parser.client.max-body-size"),
+		Proxies:       viper.GetStringSlice("parser.client.proxies"),
+		ProxyRotation: viper.GetString("parser.client.proxy-rotation"),
+	}
+
+	if def.Client.UserAgent != "" || len(def.Client.UserAgents) != 0 {
+		settings.UserAgent = def.Client.UserAgent
+		settings.UserAgents = def.Client.UserAgents
+	}
+
+	settings.Headers = maps.Clone(settings.Headers)
+	if settings.Headers == nil {
+		settings.Headers = make(map[string]string, len(def.Client.Headers))
+	}
+	maps.Copy(settings.Headers, def.Client.Headers)
+
+	settings.Cookies = append(settings.Cookies, def.Client.Cookies...)
+
+	if def.Client.Timeout != "" {
+		settings.Timeout = def.Client.Timeout
+	}
+
+	if def.Client.MaxBodySize != 0 {
+		settings.MaxBodySize = def.Client.MaxBodySize
+	}
+
+	if len(def.Client.Proxies) != 0 {
+		settings.Proxies = def.Client.Proxies
+	}
+
+	if def.Client.ProxyRotation != "" {
+		settings.ProxyRotation = def.Client.ProxyRotation
+	}
+
+	return newClient(settings)
+}
+
+func newClient(settings models.Client) (*client, error) {
+	c := &client{
+		userAgents:  settings.UserAgents,
+		headers:     settings.Headers,
+		maxBodySize: settings.MaxBodySize,
+		rotation:    settings.ProxyRotation,
+	}
+
+	if settings.UserAgent != "" {
+		c.userAgents = append([]string{settings.UserAgent}, c.userAgents...)
+	}
+
+	if c.maxBodySize < 0 {
+		return nil, ErrMaxBodySizeIsNegative
+	}
+
+	if c.rotation == "" {
+		c.rotation = models.ProxyRotationRoundRobin
+	}
+
+	if c.rotation != models.ProxyRotationRoundRobin && c.rotation != models.ProxyRotationRandom {
+		return nil, ErrUnknownProxyRotation
+	}
+
+	c.timeout = defaultTimeout
+	if settings.Timeout != "" {
+		var err error
+		c.timeout, err = time.ParseDuration(settings.Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.timeout <= 0 {
+			return nil, ErrTimeoutIsNotPositive
+		}
+	}
+
+	for _, cookie := range settings.Cookies {
+		if name, _, ok := strings.Cut(cookie, "="); !ok || strings.TrimSpace(name) == "" {
+			return nil, ErrCookieIsInvalid
+		}
+	}
+	c.cookie = strings.Join(settings.Cookies, "; ")
+
+	for _, rawProxy := range settings.Proxies {
+		proxy, err := url.Parse(rawProxy)
+		if err != nil {
+			return nil, err
+		}
+
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, ErrUnknownProxyScheme
+		}
+
+		c.proxies = append(c.proxies, proxy)
+	}
+
+	return c, nil
+}
+
+// apply sets user agent, headers and cookies to every request of the collector and marks requests with habType.
+func (c *client) apply(collector *colly.Collector, habType string) {
+	if c.maxBodySize != 0 {
+		collector.MaxBodySize = c.maxBodySize
+	}
+
+	collector.OnRequest(func(request *colly.Request) {
+		if len(c.userAgents) != 0 {
+			request.Headers.Set("User-Agent", c.userAgents[c.pick(&c.userAgentsCounter, len(c.userAgents))])
+		}
+
+		for key, value := range c.headers {
+			request.Headers.Set(key, value)
+		}
+
+		if c.cookie != "" {
+			request.Headers.Set("Cookie", c.cookie)
+		}
+
+		request.Headers.Set(habHeader, habType)
+	})
+}
+
+// pick returns index of the next user agent or proxy according to rotation.
+func (c *client) pick(counter *atomic.Uint64, n int) int {
+	if c.rotation == models.ProxyRotationRandom {
+		return rand.IntN(n)
+	}
+
+	return int((counter.Add(1) - 1) % uint64(n))
+}
+
+// roundTrip sends request through the next proxy and cancels it after timeout.
+func (c *client) roundTrip(next fetcher.Fetcher, request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	if len(c.proxies) != 0 {
+		ctx = fetcher.WithProxy(ctx, c.proxies[c.pick(&c.proxiesCounter, len(c.proxies))])
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	response, err := next.RoundTrip(request.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// cancelBody cancels context of the request, when response body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,191 @@
+package parser
+
+import (
+	"github.com/gocolly/colly/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/url"
+	"sync"
+	"testTask/internal/fetcher"
+	"testTask/internal/models"
+	"time"
+)
+
+// collectorFactory creates collector to download one page of the hab.
+type collectorFactory func() *colly.Collector
+
+// collectors creates collectors of all habs. All collectors are clones of the base collector,
+// so they share its backend with limit rules of the domains and robots.txt cache.
+// Clones also share storage of visited urls, so revisits are allowed to parse pages again.
+// Clones share HTTP client as well, so collectors are its transport, which applies timeout and proxy
+// of the hab to its requests, and requests without hab, like robots.txt, are sent with the global client.
+type collectors struct {
+	mx      sync.Mutex
+	base    *colly.Collector
+	next    fetcher.Fetcher
+	global  *client
+	clients map[string]*client
+	domains map[string]struct{}
+}
+
+// politeness is a resolved politeness settings of the hab.
+type politeness struct {
+	parallelism int
+	delay       time.Duration
+	randomDelay time.Duration
+	obeyRobots  bool
+}
+
+func newCollectors(pageFetcher fetcher.Fetcher) (*collectors, error) {
+	global, err := habClient(models.HabDefinition{})
+	if err != nil {
+		return nil, err
+	}
+
+	cl := &collectors{
+		base:    colly.NewCollector(colly.AllowURLRevisit()),
+		next:    pageFetcher,
+		global:  global,
+		clients: make(map[string]*client),
+		domains: make(map[string]struct{}),
+	}
+
+	// timeouts are applied by clients of the habs
+	cl.base.SetRequestTimeout(0)
+	cl.base.WithTransport(cl)
+	return cl, nil
+}
+
+func (cl *collectors) RoundTrip(request *http.Request) (*http.Response, error) {
+	habType := request.Header.Get(habHeader)
+	request.Header.Del(habHeader)
+
+	cl.mx.Lock()
+	c, ok := cl.clients[habType]
+	cl.mx.Unlock()
+
+	if !ok {
+		c = cl.global
+	}
+
+	return c.roundTrip(cl.next, request)
+}
+
+// forHab returns factory of the hab collectors, which send requests with HTTP client settings of the hab,
+// and register, which saves HTTP client of the hab and adds limit rules for its domains.
+// register must be called only when the hab is accepted, so that rejected definition does not change the running hab.
+// Domain can have only one limit rule, so if several habs have the same domain, rule of the first one is used.
+func (cl *collectors) forHab(def models.HabDefinition) (collectorFactory, func() error, error) {
+	settings, err := habPoliteness(def)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := habClient(def)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	register := func() error {
+		cl.mx.Lock()
+		defer cl.mx.Unlock()
+
+		for _, domain := range habDomains(def) {
+			if _, ok := cl.domains[domain]; ok {
+				continue
+			}
+
+			err := cl.base.Limit(&colly.LimitRule{
+				DomainGlob:  domain,
+				Parallelism: settings.parallelism,
+				Delay:       settings.delay,
+				RandomDelay: settings.randomDelay,
+			})
+			if err != nil {
+				return err
+			}
+
+			logrus.Infof("limit requests to %s: parallelism: %d, delay: %s, random delay: %s",
+				domain, settings.parallelism, settings.delay, settings.randomDelay)
+			cl.domains[domain] = struct{}{}
+		}
+
+		cl.clients[def.HabType] = c
+		return nil
+	}
+
+	return func() *colly.Collector {
+		collector := cl.base.Clone()
+		collector.IgnoreRobotsTxt = !settings.obeyRobots
+		c.apply(collector, def.HabType)
+		return collector
+	}, register, nil
+}
+
+// habPoliteness returns politeness settings of the hab, not specified settings are taken from parser.politeness.
+func habPoliteness(def models.HabDefinition) (politeness, error) {
+	settings := politeness{
+		parallelism: def.Politeness.Parallelism,
+		obeyRobots:  viper.GetBool("parser.politeness.obey-robots"),
+	}
+
+	if settings.parallelism < 0 {
+		return politeness{}, ErrParallelismIsNegative
+	}
+
+	if settings.parallelism == 0 {
+		settings.parallelism = viper.GetInt("parser.politeness.parallelism")
+	}
+
+	if def.Politeness.ObeyRobots != nil {
+		settings.obeyRobots = *def.Politeness.ObeyRobots
+	}
+
+	var err error
+	settings.delay, err = politenessDuration(def.Politeness.Delay, "parser.politeness.delay")
+	if err != nil {
+		return politeness{}, err
+	}
+
+	settings.randomDelay, err = politenessDuration(def.Politeness.RandomDelay, "parser.politeness.random-delay")
+	if err != nil {
+		return politeness{}, err
+	}
+
+	return settings, nil
+}
+
+func politenessDuration(value string, key string) (time.Duration, error) {
+	if value == "" {
+		return viper.GetDuration(key), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, ErrDelayIsNegative
+	}
+
+	return d, nil
+}
+
+// habDomains returns hosts of the main page url and base url of the hab.
+func habDomains(def models.HabDefinition) []string {
+	domains := make([]string, 0, 2)
+	for _, rawUrl := range []string{def.MainPageUrl, def.BaseUrl} {
+		u, err := url.Parse(rawUrl)
+		if err != nil || u.Host == "" {
+			continue
+		}
+
+		if len(domains) == 0 || domains[0] != u.Host {
+			domains = append(domains, u.Host)
+		}
+	}
+
+	return domains
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"github.com/gocolly/colly/v2"
+	"net/http"
+	"sync"
+)
+
+// pageVisitor downloads page with collector.
+type pageVisitor func(collector *colly.Collector, pageUrl string) error
+
+// visitPage downloads page unconditionally.
+func visitPage(collector *colly.Collector, pageUrl string) error {
+	return collector.Visit(pageUrl)
+}
+
+type pageValidator struct {
+	etag         string
+	lastModified string
+}
+
+// pageValidators keeps ETag and Last-Modified of the downloaded pages, so that they are downloaded again
+// only if they are modified. Validators are kept in memory, so the first request after restart is unconditional.
+// Validators of the downloaded pages are pending until commit, so that page, which failed to be parsed,
+// is downloaded again by the next run.
+type pageValidators struct {
+	mx      sync.Mutex
+	pages   map[string]pageValidator
+	pending map[string]pageValidator
+}
+
+func newPageValidators() *pageValidators {
+	return &pageValidators{
+		pages:   make(map[string]pageValidator),
+		pending: make(map[string]pageValidator),
+	}
+}
+
+// commit keeps validators of the pages downloaded since the previous commit or discard.
+func (pv *pageValidators) commit() {
+	pv.mx.Lock()
+	defer pv.mx.Unlock()
+
+	for pageUrl, validator := range pv.pending {
+		if validator == (pageValidator{}) {
+			delete(pv.pages, pageUrl)
+			continue
+		}
+
+		pv.pages[pageUrl] = validator
+	}
+
+	clear(pv.pending)
+}
+
+// discard forgets validators of the pages downloaded since the previous commit or discard.
+func (pv *pageValidators) discard() {
+	pv.mx.Lock()
+	clear(pv.pending)
+	pv.mx.Unlock()
+}
+
+// visit downloads page with If-None-Match and If-Modified-Since headers, if validators of the page are known.
+// Validators of the response are pending until commit. If server responds with 304 Not Modified,
+// visit returns ErrPageIsNotModified.
+func (pv *pageValidators) visit(collector *colly.Collector, pageUrl string) error {
+	collector.OnRequest(func(request *colly.Request) {
+		pv.mx.Lock()
+		validator, ok := pv.pages[request.URL.String()]
+		pv.mx.Unlock()
+
+		if !ok {
+			return
+		}
+
+		if validator.etag != "" {
+			request.Headers.Set("If-None-Match", validator.etag)
+		}
+
+		if validator.lastModified != "" {
+			request.Headers.Set("If-Modified-Since", validator.lastModified)
+		}
+	})
+
+	collector.OnResponse(func(response *colly.Response) {
+		validator := pageValidator{
+			etag:         response.Headers.Get("ETag"),
+			lastModified: response.Headers.Get("Last-Modified"),
+		}
+
+		pv.mx.Lock()
+		pv.pending[response.Request.URL.String()] = validator
+		pv.mx.Unlock()
+	})
+
+	var notModified bool
+	collector.OnError(func(response *colly.Response, err error) {
+		if response.StatusCode == http.StatusNotModified {
+			notModified = true
+		}
+	})
+
+	err := collector.Visit(pageUrl)
+	if notModified {
+		return ErrPageIsNotModified
+	}
+
+	return err
+}
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"regexp"
+	"strings"
+	"testTask/internal/models"
+	"time"
+	_ "time/tzdata"
+)
+
+var russianWordRegexp = regexp.MustCompile(`\p{Cyrillic}+\.?`)
+
+// russianMonths maps russian month names and their abbreviations to english month names.
+var russianMonths = map[string]string{
+	"января": "January", "январь": "January", "янв": "January",
+	"февраля": "February", "февраль": "February", "фев": "February",
+	"марта": "March", "март": "March", "мар": "March",
+	"апреля": "April", "апрель": "April", "апр": "April",
+	"мая": "May", "май": "May",
+	"июня": "June", "июнь": "June", "июн": "June",
+	"июля": "July", "июль": "July", "июл": "July",
+	"августа": "August", "август": "August", "авг": "August",
+	"сентября": "September", "сентябрь": "September", "сен": "September", "сент": "September",
+	"октября": "October", "октябрь": "October", "окт": "October",
+	"ноября": "November", "ноябрь": "November", "ноя": "November",
+	"декабря": "December", "декабрь": "December", "дек": "December",
+	"г": "",
+}
+
+// parseDate parses date with layout of the field, RFC 3339 is used if layout is not specified.
+// Russian month names are replaced with english ones, so "12 марта 2024" is parsed with layout "2 January 2006".
+// If date does not contain timezone, timezone of the field or parser.default-timezone is used.
+func parseDate(value string, field models.FieldSelector) (time.Time, error) {
+	layout := field.Layout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	location, err := dateLocation(field)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	value = russianWordRegexp.ReplaceAllStringFunc(value, func(word string) string {
+		if month, ok := russianMonths[strings.ToLower(strings.TrimSuffix(word, "."))]; ok {
+			return month
+		}
+
+		return word
+	})
+
+	return time.ParseInLocation(layout, strings.Join(strings.Fields(value), " "), location)
+}
+
+func dateLocation(field models.FieldSelector) (*time.Location, error) {
+	timezone := field.Timezone
+	if timezone == "" {
+		timezone = viper.GetString("parser.default-timezone")
+	}
+
+	return time.LoadLocation(timezone)
+}
+
+// reparseConvertedArticles sends to parse again articles, which dates were reset,
+// when dates started to be stored with the date part. Articles stay marked until they are parsed or moved to dead letters.
+// Articles of the habs, which can parse them only from the feed, are not parsed again.
+func (p *Parser) reparseConvertedArticles() {
+	p.mx.RLock()
+	habTypes := make([]string, 0, len(p.habs))
+	for habType, h := range p.habs {
+		if !h.parseFunctions.itemRequired {
+			habTypes = append(habTypes, habType)
+		}
+	}
+	p.mx.RUnlock()
+
+	for _, habType := range habTypes {
+		urls, err := p.storage.GetArticleUrlsToReparse(habType)
+		if err != nil {
+			logrus.Errorf("failed to get articles to reparse of %s, error: %v", habType, err)
+			continue
+		}
+
+		if len(urls) != 0 {
+			logrus.Infof("parse again %d articles with reset date of %s", len(urls), habType)
+		}
+
+		for _, url := range urls {
+			select {
+			case p.c <- articleInfo{url: url, habType: habType}:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}
+}
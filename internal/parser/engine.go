@@ -0,0 +1,346 @@
+package parser
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+	"github.com/kennygrant/sanitize"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testTask/internal/models"
+	"time"
+)
+
+// loadHabDefinitions reads hab definitions from the habs section of configuration.
+func loadHabDefinitions() ([]models.HabDefinition, error) {
+	defs := make([]models.HabDefinition, 0)
+	err := viper.UnmarshalKey("habs", &defs)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]struct{}, len(defs))
+	for _, def := range defs {
+		err = validateHabDefinition(def)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := used[def.HabType]; ok {
+			return nil, ErrHabIsAlreadyExist
+		}
+		used[def.HabType] = struct{}{}
+	}
+
+	return defs, nil
+}
+
+func validateHabDefinition(def models.HabDefinition) error {
+	if def.HabType == "" {
+		return ErrHabIsEmpty
+	}
+
+	if def.MainPageUrl == "" {
+		return ErrMainPageUrlIsEmpty
+	}
+
+	if _, err := url.ParseRequestURI(def.MainPageUrl); err != nil {
+		return err
+	}
+
+	switch def.Source {
+	case "", models.HabSourceHtml:
+		if def.LinkSelector == "" {
+			return ErrLinkSelectorIsEmpty
+		}
+
+		if !hasFieldSelectors(def) {
+			return ErrFieldSelectorIsEmpty
+		}
+
+	case models.HabSourceFeed:
+		if def.FeedFallback && !hasFieldSelectors(def) {
+			return ErrFieldSelectorIsEmpty
+		}
+
+	case models.HabSourceSitemap:
+		if !hasFieldSelectors(def) {
+			return ErrFieldSelectorIsEmpty
+		}
+
+		if _, err := regexp.Compile(def.SitemapPattern); err != nil {
+			return err
+		}
+
+	default:
+		return ErrUnknownSource
+	}
+
+	if _, err := regexp.Compile(def.ArticlePattern); err != nil {
+		return err
+	}
+
+	for _, field := range habFieldSelectors(def) {
+		if err := validateFieldSelector(field); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dateLocation(def.Fields.PublishDate); err != nil {
+		return err
+	}
+
+	if def.Pagination.MaxPages < 0 {
+		return ErrMaxPagesIsNegative
+	}
+
+	if def.Pagination.MaxPages > 1 && def.Pagination.NextSelector == "" && def.Pagination.UrlTemplate == "" {
+		return ErrPaginationIsNotSpecified
+	}
+
+	if _, err := habPoliteness(def); err != nil {
+		return err
+	}
+
+	if _, err := habClient(def); err != nil {
+		return err
+	}
+
+	_, err := habSchedule(def)
+	return err
+}
+
+func hasFieldSelectors(def models.HabDefinition) bool {
+	return !def.Fields.Title.IsEmpty() && !def.Fields.Username.IsEmpty() && !def.Fields.UsernameUrl.IsEmpty()
+}
+
+// habFieldSelectors returns all field and metric selectors of the hab.
+func habFieldSelectors(def models.HabDefinition) []models.FieldSelector {
+	return []models.FieldSelector{
+		def.Fields.Title, def.Fields.Username, def.Fields.UsernameUrl, def.Fields.PublishDate, def.Fields.Body, def.Fields.Tags,
+		def.Metrics.Rating, def.Metrics.Views, def.Metrics.Bookmarks, def.Metrics.Comments,
+	}
+}
+
+// newHabParseFunctions builds habParseFunctions from hab definition according to its source.
+// All pages of the hab are downloaded with collectors from newCollector.
+func newHabParseFunctions(def models.HabDefinition, newCollector collectorFactory) habParseFunctions {
+	switch def.Source {
+	case models.HabSourceFeed:
+		return newFeedParseFunctions(def, newCollector)
+
+	case models.HabSourceSitemap:
+		f := newHtmlParseFunctions(def, newCollector)
+		f.source = models.HabSourceSitemap
+		if def.SitemapPattern != "" {
+			f.sitemapPattern = regexp.MustCompile(def.SitemapPattern)
+		}
+
+		return f
+	}
+
+	return newHtmlParseFunctions(def, newCollector)
+}
+
+// newHtmlParseFunctions builds habParseFunctions, which parse html pages of the hab with selectors from definition.
+func newHtmlParseFunctions(def models.HabDefinition, newCollector collectorFactory) habParseFunctions {
+	return habParseFunctions{
+		parseMainPage: func(pageUrl string, page int, buf []articleLink, visit pageVisitor) ([]articleLink, string, error) {
+			collector := newCollector()
+
+			collector.OnHTML(def.LinkSelector, func(htmlElement *colly.HTMLElement) {
+				articleUrl := htmlElement.Attr("href")
+				if articleUrl == "" {
+					return
+				}
+
+				buf = append(buf, articleLink{url: normalizeUrl(resolveUrl(def.BaseUrl, htmlElement.Request, articleUrl))})
+			})
+
+			var nextPageUrl string
+			if def.Pagination.NextSelector != "" {
+				collector.OnHTML(def.Pagination.NextSelector, func(htmlElement *colly.HTMLElement) {
+					link := htmlElement.Attr("href")
+					if nextPageUrl == "" && link != "" {
+						nextPageUrl = resolveUrl(def.BaseUrl, htmlElement.Request, link)
+					}
+				})
+			}
+
+			err := visit(collector, pageUrl)
+			if err != nil {
+				return buf, "", err
+			}
+
+			if nextPageUrl == "" && def.Pagination.UrlTemplate != "" {
+				nextPageUrl = pageUrlFromTemplate(def, page+1)
+			}
+
+			return buf, nextPageUrl, nil
+		},
+
+		parseArticlePage: func(url string, _ *models.ArticleData) (*models.ArticleData, error) {
+			collector := newCollector()
+
+			var data models.ArticleData
+			data.Url = url
+			data.HabType = def.HabType
+
+			onField(collector, def.Fields.Title, func(element fieldElement, value string) {
+				data.Title = value
+			})
+
+			onField(collector, def.Fields.Username, func(element fieldElement, value string) {
+				data.Username = value
+			})
+
+			onField(collector, def.Fields.UsernameUrl, func(element fieldElement, value string) {
+				data.UsernameUrl = resolveUrl(def.BaseUrl, element.request, value)
+			})
+
+			onField(collector, def.Fields.PublishDate, func(element fieldElement, value string) {
+				var err error
+				data.PublishData, err = parseDate(value, def.Fields.PublishDate)
+				if err != nil {
+					logrus.Errorf("failed to parse publish date, URL: %s, error: %v", url, err)
+				}
+			})
+
+			onBody(collector, def.Fields.Body, func(bodyHtml string, bodyText string) {
+				data.BodyHtml, data.BodyText = bodyHtml, bodyText
+			})
+
+			used := make(map[string]struct{})
+			onValues(collector, def.Fields.Tags, func(element fieldElement, value string) {
+				tag := NormalizeTag(value)
+				if _, ok := used[tag]; ok || tag == "" {
+					return
+				}
+
+				used[tag] = struct{}{}
+				data.Tags = append(data.Tags, tag)
+			})
+
+			if !def.Metrics.IsEmpty() {
+				data.Metrics = &models.ArticleMetrics{}
+				onMetric(collector, def.Metrics.Rating, &data.Metrics.Rating)
+				onMetric(collector, def.Metrics.Views, &data.Metrics.Views)
+				onMetric(collector, def.Metrics.Bookmarks, &data.Metrics.Bookmarks)
+				onMetric(collector, def.Metrics.Comments, &data.Metrics.Comments)
+			}
+
+			err := collector.Visit(url)
+			if err != nil {
+				return &data, err
+			}
+
+			if data.Metrics != nil {
+				data.Metrics.CollectedAt = time.Now()
+			}
+
+			return &data, nil
+		},
+
+		habMainPageUrl: def.MainPageUrl,
+		maxPages:       max(def.Pagination.MaxPages, 1),
+		paginated:      def.Pagination.NextSelector != "" || def.Pagination.UrlTemplate != "",
+		hasMetrics:     !def.Metrics.IsEmpty(),
+		newCollector:   newCollector,
+	}
+}
+
+// pageUrlFromTemplate builds url of the listing page with number page from pagination url template.
+// Relative template is resolved against base url of the hab, or against its main page url.
+func pageUrlFromTemplate(def models.HabDefinition, page int) string {
+	pageUrl := strings.ReplaceAll(def.Pagination.UrlTemplate, "{n}", strconv.Itoa(page))
+
+	baseUrl := def.BaseUrl
+	if baseUrl == "" {
+		baseUrl = def.MainPageUrl
+	}
+
+	return resolveReference(baseUrl, pageUrl)
+}
+
+// parseMetric parses counters like 15, +15, -3, 1 234, 1.2K or 3M.
+func parseMetric(value string) (int, error) {
+	value = strings.Join(strings.Fields(strings.ReplaceAll(value, "\u00a0", " ")), "")
+	value = strings.ReplaceAll(value, ",", ".")
+
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(value, "K") || strings.HasSuffix(value, "k"):
+		multiplier = 1e3
+		value = value[:len(value)-1]
+	case strings.HasSuffix(value, "M") || strings.HasSuffix(value, "m"):
+		multiplier = 1e6
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(math.Round(number * multiplier)), nil
+}
+
+// NormalizeTag brings tag to the form, in which it is stored:
+// tag is lowercased and spaces are collapsed.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// sanitizeBody returns sanitized html and plain text of the article body html.
+// In plain text empty lines are removed and every line is trimmed.
+func sanitizeBody(rawHtml string) (string, string, error) {
+	bodyHtml, err := sanitize.HTMLAllowing(rawHtml)
+	if err != nil {
+		return "", "", err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawHtml))
+	if err != nil {
+		return "", "", err
+	}
+
+	text := doc.Find("script, style").Remove().End().Text()
+	lines := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.TrimSpace(bodyHtml), strings.Join(lines, "\n"), nil
+}
+
+// resolveUrl makes link absolute using base url of the hab,
+// or url of the page, if base url is not specified.
+func resolveUrl(baseUrl string, request *colly.Request, link string) string {
+	link = strings.TrimSpace(link)
+	if baseUrl == "" {
+		return request.AbsoluteURL(link)
+	}
+
+	return resolveReference(baseUrl, link)
+}
+
+func resolveReference(baseUrl string, link string) string {
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return link
+	}
+
+	ref, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	return base.ResolveReference(ref).String()
+}
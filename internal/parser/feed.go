@@ -0,0 +1,332 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"github.com/gocolly/colly/v2"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html/charset"
+	"strings"
+	"testTask/internal/models"
+	"time"
+)
+
+var ErrUnknownFeedFormat = errors.New("unknown feed format, only RSS 2.0 and Atom are supported")
+
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+}
+
+type rssFeed struct {
+	Items []rssItem `xml:"channel>item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Author      string   `xml:"author"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type atomFeed struct {
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title  string     `xml:"title"`
+	Links  []atomLink `xml:"link"`
+	Author struct {
+		Name string `xml:"name"`
+		Uri  string `xml:"uri"`
+	} `xml:"author"`
+	Published  string `xml:"published"`
+	Updated    string `xml:"updated"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Content string `xml:"content"`
+	Summary string `xml:"summary"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// newFeedParseFunctions builds habParseFunctions, which fill articles from RSS or Atom feed items.
+// Main page of the hab is the feed url. Items are passed to parseArticlePage with article links,
+// if hab has feed fallback, missing fields are taken from the article page with selectors from definition,
+// otherwise article can't be parsed without its item.
+func newFeedParseFunctions(def models.HabDefinition, newCollector collectorFactory) habParseFunctions {
+	var fallback habParseFunctions
+	if def.FeedFallback {
+		fallback = newHtmlParseFunctions(def, newCollector)
+	}
+
+	return habParseFunctions{
+		parseMainPage: func(pageUrl string, page int, buf []articleLink, visit pageVisitor) ([]articleLink, string, error) {
+			articles, nextPageUrl, err := fetchFeed(newCollector(), def, pageUrl, visit)
+			if err != nil {
+				return buf, "", err
+			}
+
+			for _, article := range articles {
+				buf = append(buf, articleLink{url: article.Url, item: article})
+			}
+
+			return buf, nextPageUrl, nil
+		},
+
+		parseArticlePage: func(url string, item *models.ArticleData) (*models.ArticleData, error) {
+			data := &models.ArticleData{Url: url, HabType: def.HabType}
+
+			ok := item != nil
+			if ok {
+				*data = *item
+			} else if !def.FeedFallback {
+				return data, ErrFeedItemIsRequired
+			}
+
+			if def.FeedFallback && (isIncomplete(data) || !def.Metrics.IsEmpty()) {
+				page, err := fallback.parseArticlePage(url, nil)
+				if err != nil && !ok {
+					return data, err
+				}
+
+				if err != nil {
+					logrus.Errorf("failed to parse article page, URL: %s, error: %v", url, err)
+				}
+
+				fillMissingFields(data, page)
+			}
+
+			return data, nil
+		},
+
+		habMainPageUrl:   def.MainPageUrl,
+		maxPages:         max(def.Pagination.MaxPages, 1),
+		paginated:        true,
+		itemRequired:     !def.FeedFallback,
+		hasMetrics:       def.FeedFallback && !def.Metrics.IsEmpty(),
+		authorIsOptional: !def.FeedFallback,
+		newCollector:     newCollector,
+	}
+}
+
+// fetchFeed downloads feed by visit and returns its articles and url of the next feed page, if feed has it.
+func fetchFeed(collector *colly.Collector, def models.HabDefinition, feedUrl string, visit pageVisitor) ([]*models.ArticleData, string, error) {
+
+	var body []byte
+	collector.OnResponse(func(response *colly.Response) {
+		body = response.Body
+	})
+
+	err := visit(collector, feedUrl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return parseFeed(def, feedUrl, body)
+}
+
+// parseFeed parses RSS 2.0 or Atom feed. Relative links are resolved against base url of the hab or feed url.
+func parseFeed(def models.HabDefinition, feedUrl string, body []byte) ([]*models.ArticleData, string, error) {
+	baseUrl := def.BaseUrl
+	if baseUrl == "" {
+		baseUrl = feedUrl
+	}
+
+	var root struct {
+		XMLName xml.Name
+	}
+
+	err := decodeFeed(body, &root)
+	if err != nil {
+		return nil, "", err
+	}
+
+	articles := make([]*models.ArticleData, 0)
+
+	switch root.XMLName.Local {
+	case "rss":
+		var feed rssFeed
+		err = decodeFeed(body, &feed)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, item := range feed.Items {
+			article := &models.ArticleData{
+				Url:      normalizeUrl(resolveReference(baseUrl, strings.TrimSpace(item.Link))),
+				Title:    strings.TrimSpace(item.Title),
+				Username: strings.TrimSpace(item.Creator),
+				HabType:  def.HabType,
+				Tags:     normalizeTags(item.Categories),
+			}
+
+			if article.Username == "" {
+				article.Username = strings.TrimSpace(item.Author)
+			}
+
+			article.PublishData = parseFeedDate(item.PubDate)
+			fillFeedBody(article, item.Content, item.Description)
+			articles = append(articles, article)
+		}
+
+		return articles, "", nil
+
+	case "feed":
+		var feed atomFeed
+		err = decodeFeed(body, &feed)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, entry := range feed.Entries {
+			categories := make([]string, 0, len(entry.Categories))
+			for _, category := range entry.Categories {
+				categories = append(categories, category.Term)
+			}
+
+			article := &models.ArticleData{
+				Url:      normalizeUrl(resolveReference(baseUrl, atomLinkHref(entry.Links, "alternate"))),
+				Title:    strings.TrimSpace(entry.Title),
+				Username: strings.TrimSpace(entry.Author.Name),
+				HabType:  def.HabType,
+				Tags:     normalizeTags(categories),
+			}
+
+			if entry.Author.Uri != "" {
+				article.UsernameUrl = resolveReference(baseUrl, strings.TrimSpace(entry.Author.Uri))
+			}
+
+			article.PublishData = parseFeedDate(entry.Published)
+			if article.PublishData.IsZero() {
+				article.PublishData = parseFeedDate(entry.Updated)
+			}
+
+			fillFeedBody(article, entry.Content, entry.Summary)
+			articles = append(articles, article)
+		}
+
+		var nextPageUrl string
+		if next := atomLinkHref(feed.Links, "next"); next != "" {
+			nextPageUrl = resolveReference(baseUrl, next)
+		}
+
+		return articles, nextPageUrl, nil
+	}
+
+	return nil, "", ErrUnknownFeedFormat
+}
+
+func decodeFeed(body []byte, v any) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	return decoder.Decode(v)
+}
+
+// atomLinkHref returns href of the link with rel, link without rel is considered alternate.
+func atomLinkHref(links []atomLink, rel string) string {
+	for _, link := range links {
+		if link.Rel == rel || (link.Rel == "" && rel == "alternate") {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+
+	return ""
+}
+
+func parseFeedDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range feedDateLayouts {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date
+		}
+	}
+
+	logrus.Errorf("failed to parse feed date: %s", value)
+	return time.Time{}
+}
+
+func fillFeedBody(article *models.ArticleData, contents ...string) {
+	for _, content := range contents {
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		var err error
+		article.BodyHtml, article.BodyText, err = sanitizeBody(content)
+		if err != nil {
+			logrus.Errorf("failed to sanitize feed body, URL: %s, error: %v", article.Url, err)
+		}
+
+		return
+	}
+}
+
+func normalizeTags(tags []string) []string {
+	used := make(map[string]struct{}, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if _, ok := used[tag]; ok || tag == "" {
+			continue
+		}
+
+		used[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+func isIncomplete(data *models.ArticleData) bool {
+	return data.Title == "" || data.Username == "" || data.UsernameUrl == "" || data.PublishData.IsZero()
+}
+
+// fillMissingFields fills empty fields of data with fields of the article parsed from the page.
+func fillMissingFields(data *models.ArticleData, page *models.ArticleData) {
+	if data.Title == "" {
+		data.Title = page.Title
+	}
+
+	if data.Username == "" {
+		data.Username = page.Username
+	}
+
+	if data.UsernameUrl == "" {
+		data.UsernameUrl = page.UsernameUrl
+	}
+
+	if data.PublishData.IsZero() {
+		data.PublishData = page.PublishData
+	}
+
+	if data.BodyHtml == "" {
+		data.BodyHtml, data.BodyText = page.BodyHtml, page.BodyText
+	}
+
+	if len(data.Tags) == 0 {
+		data.Tags = page.Tags
+	}
+
+	if data.Metrics == nil {
+		data.Metrics = page.Metrics
+	}
+}
@@ -2,191 +2,347 @@ package parser
 
 import (
 	"context"
-	"github.com/gocolly/colly/v2"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
-	"strings"
-	"testTask/internal/cast"
+	"regexp"
+	"sync"
 	"testTask/internal/models"
 	"time"
 )
 
-var habsMap = map[string]habParseFunctions{
-	"habr": {
-		parseMainPage: func(buf []string) []string {
-			collector := colly.NewCollector()
+type hab struct {
+	habType        string
+	parseFunctions habParseFunctions
+	storage        Storage
+	runsSaver      *runsSaver
+
+	mx         sync.Mutex
+	paused     bool
+	running    bool
+	crawls     sync.WaitGroup
+	runs       []*crawlRun
+	schedule   schedule
+	overridden bool
+	lastRun    time.Time
+	nextRun    time.Time
+	timer      *time.Timer
+
+	domains        []string
+	articlePattern *regexp.Regexp
+	health         *habHealth
+	validators     *pageValidators
+	seenArticles   *urlCache
+	articleUrlsBuf []articleLink
+	c              chan<- articleInfo
+	ctx            context.Context
+	stop           context.CancelFunc
+}
 
-			collector.OnHTML("a.tm-title__link", func(htmlElement *colly.HTMLElement) {
-				articleUrl, ok := htmlElement.DOM.Attr("href")
-				if !ok {
-					panic("wrong attribute was given to selection")
-				}
-				buf = append(buf, strings.TrimSpace("https://habr.com"+articleUrl))
-			})
+// habParseFunctions is a set of functions to parse the hab.
+// parseMainPage downloads listing page with number page by visit, appends found article links to buf
+// and returns url of the next listing page, which is empty, if there is no next page.
+// If listing page is not downloaded, parseMainPage returns an error.
+// If source is sitemap, article urls are taken from sitemap at habMainPageUrl instead of listing pages.
+// parseArticlePage returns article, which is never nil, and error, if article page was not downloaded.
+// item is the feed item of the article, if itemRequired, article can't be parsed without it.
+// If authorIsOptional, articles without username and its url are valid. If paginated is false,
+// hab has no way to get the next listing page. Feeds are always paginated by their next links.
+type habParseFunctions struct {
+	parseMainPage    func(pageUrl string, page int, buf []articleLink, visit pageVisitor) ([]articleLink, string, error)
+	parseArticlePage func(url string, item *models.ArticleData) (*models.ArticleData, error)
+	itemRequired     bool
+	habMainPageUrl   string
+	maxPages         int
+	paginated        bool
+	hasMetrics       bool
+	authorIsOptional bool
+	source           string
+	sitemapPattern   *regexp.Regexp
+	newCollector     collectorFactory
+}
 
-			err := collector.Visit("https://habr.com/ru/articles/")
-			if err != nil {
-				logrus.Errorf("failed to visit url, URL: %s, error: %v", "https://habr.com/ru/articles/", err)
-			}
+// articleLink is an article found on the listing page, item is the feed item of the article, if hab is a feed.
+type articleLink struct {
+	url  string
+	item *models.ArticleData
+}
 
-			return buf
-		},
+func newHab(habType string, f habParseFunctions, s schedule, c chan articleInfo, storage Storage, runs *runsSaver) *hab {
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
 
-		parseArticlePage: func(url string) *models.ArticleData {
-			collector := colly.NewCollector()
+	nextRun := s.next(time.Now())
 
-			var data models.ArticleData
-			data.Url = url
+	return &hab{
+		habType:        habType,
+		parseFunctions: f,
+		storage:        storage,
+		runsSaver:      runs,
+		schedule:       s,
+		nextRun:        nextRun,
+		timer:          time.NewTimer(time.Until(nextRun)),
+		validators:     newPageValidators(),
+		seenArticles:   newUrlCache(viper.GetInt("parser.seen-articles-cache-size")),
+		articleUrlsBuf: make([]articleLink, 0),
+		c:              c,
+		ctx:            ctx,
+		stop:           stop,
+	}
+}
 
-			collector.OnHTML("a.tm-user-info__username", func(htmlElement *colly.HTMLElement) {
-				data.UsernameUrl = "https://habr.com" + htmlElement.Attr("href")
-				data.Username = htmlElement.Text
-			})
+// newHabFromDefinition builds hab from definition. Returned register must be called, when hab is accepted,
+// to apply its HTTP client settings and limit rules, see collectors.forHab.
+func newHabFromDefinition(def models.HabDefinition, c chan articleInfo, storage Storage, runs *runsSaver,
+	cl *collectors) (*hab, func() error, error) {
+	s, err := habSchedule(def)
+	if err != nil {
+		return nil, nil, err
+	}
 
-			collector.OnHTML("h1.tm-title", func(htmlElement *colly.HTMLElement) {
-				data.Title = htmlElement.Text
-			})
+	newCollector, register, err := cl.forHab(def)
+	if err != nil {
+		return nil, nil, err
+	}
 
-			collector.OnHTML("span.tm-article-datetime-published", func(htmlElement *colly.HTMLElement) {
-				publishData, _ := htmlElement.DOM.Children().Attr("title")
-				slc := cast.StringToByteArray(publishData)
-				slc = append(slc[:10], slc[11:]...)
-				dt := publishData[:(len(publishData)-1)] + ":00"
+	h := newHab(def.HabType, newHabParseFunctions(def, newCollector), s, c, storage, runs)
+	h.health = newHabHealth(def.HabType, expectedFields(def))
+	h.domains = habDomains(def)
+	if pattern := def.ArticlePattern; pattern != "" || def.SitemapPattern != "" {
+		if pattern == "" {
+			pattern = def.SitemapPattern
+		}
 
-				var err error
-				data.PublishData, err = time.Parse(time.DateTime, dt)
-				if err != nil {
-					panic(err)
-				}
-			})
+		h.articlePattern = regexp.MustCompile(pattern)
+	}
 
-			data.HabType = "habr"
+	return h, register, nil
+}
 
-			err := collector.Visit(url)
-			if err != nil {
-				logrus.Errorf("failed to visit url, URL: %s, error: %v", url, err)
-			}
+// restoreState applies scheduler state saved in storage.
+// If next run time has already passed, hab is parsed right after start.
+func (h *hab) restoreState(state models.HabState) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if state.Schedule != "" {
+		s, err := parseSchedule(state.Schedule)
+		if err != nil {
+			logrus.Errorf("failed to parse schedule of %s, error: %v", h.habType, err)
+		} else {
+			logrus.Infof("schedule of %s is changed through API to %s, schedule from definition is not used", h.habType, s)
+			h.schedule = s
+			h.overridden = true
+		}
+	}
 
-			return &data
-		},
+	h.lastRun = state.LastRun
+	h.nextRun = state.NextRun
+	if h.nextRun.IsZero() {
+		h.nextRun = h.schedule.next(time.Now())
+	}
 
-		habMainPageUrl: "https://habr.com/ru/articles/",
-	},
+	h.paused = state.Status == models.HabStatusPaused
+	if h.paused {
+		h.timer.Stop()
+		return
+	}
 
-	"skillbox": {
-		parseMainPage: func(buf []string) []string {
-			collector := colly.NewCollector()
+	h.resetTimer(max(time.Until(h.nextRun), 0))
+}
 
-			collector.OnHTML("a.card-articles__body-link", func(htmlElement *colly.HTMLElement) {
-				articleUrl := htmlElement.Attr("href")
+// state returns scheduler state of the hab. Schedule is returned only if it was changed through API,
+// so that schedule changed in hab definition is applied after restart.
+func (h *hab) state() models.HabState {
+	h.mx.Lock()
+	defer h.mx.Unlock()
 
-				buf = append(buf, strings.TrimSpace("https://skillbox.ru"+articleUrl))
-			})
+	status := models.HabStatusRunning
+	if h.paused {
+		status = models.HabStatusPaused
+	}
 
-			err := collector.Visit("https://skillbox.ru/media/topic/articles/")
-			if err != nil {
-				logrus.Errorf("failed to visit url, URL: %s, error: %v", "https://skillbox.ru/media/topic/articles/", err)
-			}
+	state := models.HabState{
+		Status:  status,
+		LastRun: h.lastRun,
+		NextRun: h.nextRun,
+	}
 
-			return buf
-		},
+	if h.overridden {
+		state.Schedule = h.schedule.String()
+	}
 
-		parseArticlePage: func(url string) *models.ArticleData {
-			collector := colly.NewCollector()
+	return state
+}
 
-			var data models.ArticleData
-			data.Url = url
+// currentSchedule returns schedule, by which hab is parsed.
+func (h *hab) currentSchedule() string {
+	h.mx.Lock()
+	defer h.mx.Unlock()
 
-			collector.OnHTML("div.article-author__name", func(htmlElement *colly.HTMLElement) {
-				data.Username = strings.TrimSpace(htmlElement.Text)
-			})
+	return h.schedule.String()
+}
 
-			collector.OnHTML("div.article-author__image", func(htmlElement *colly.HTMLElement) {
-				data.UsernameUrl, _ = htmlElement.DOM.Children().Attr("href")
-			})
+func (h *hab) saveState() error {
+	err := h.storage.PutHabState(h.habType, h.state())
+	if err != nil {
+		logrus.Errorf("failed to save state of %s, error: %v", h.habType, err)
+	}
 
-			collector.OnHTML("h1.article-preview__title", func(htmlElement *colly.HTMLElement) {
-				data.Title = strings.TrimSpace(htmlElement.Text)
-			})
+	return err
+}
 
-			collector.OnHTML("time.info-text", func(htmlElement *colly.HTMLElement) {
-				data.PublishData = time.Now()
-			})
+// crawl parses main page of the hab during the run, which must be started with startRun.
+// Listing pages are parsed with parseListing and habs with sitemap source are parsed with parseSitemap.
+// Main page of the scheduled run is requested conditionally, if it is not modified since the last run,
+// the run is skipped. Validators of the main page are kept only if its articles are all sent to parse.
+// Amount of found article urls is saved in health of the hab.
+func (h *hab) crawl(run *crawlRun) {
+	defer h.finishRun()
+
+	var found int
+	var err error
+	if h.parseFunctions.source == models.HabSourceSitemap {
+		found, err = h.parseSitemap(run)
+	} else {
+		found, err = h.parseListing(run)
+	}
 
-			data.HabType = "skillbox"
+	run.finishMainPage(found, err)
 
-			err := collector.Visit(url)
-			if err != nil {
-				logrus.Errorf("failed to visit url, URL: %s, error: %v", "https://skillbox", err)
-			}
+	if err == nil && h.ctx.Err() == nil {
+		h.validators.commit()
+	} else {
+		h.validators.discard()
+	}
 
-			return &data
-		},
+	if errors.Is(err, ErrPageIsNotModified) {
+		logrus.Infof("main page of %s is not modified, skip parsing", h.habType)
+		return
+	}
 
-		habMainPageUrl: "https://skillbox.ru/media/topic/articles/",
-	},
-}
+	if err != nil {
+		logrus.Errorf("failed to parse main page of %s, error: %v", h.habType, err)
+	}
 
-type hab struct {
-	habType        string
-	parseFunctions habParseFunctions
-	interval       time.Duration
-	timer          *time.Timer
-	usedArticles   map[string]struct{}
-	articleUrlsBuf []string
-	c              chan<- articleInfo
-	ctx            context.Context
-	stop           context.CancelFunc
+	h.health.recordRun(found)
 }
 
-type habParseFunctions struct {
-	parseMainPage    func(buf []string) []string
-	parseArticlePage func(url string) *models.ArticleData
-	habMainPageUrl   string
+// mainPageVisitor returns visitor of the main page of the run.
+func (h *hab) mainPageVisitor(run *crawlRun) pageVisitor {
+	if run.trigger == models.CrawlTriggerSchedule {
+		return h.validators.visit
+	}
+
+	return visitPage
 }
 
-func newHab(habType string, f habParseFunctions, c chan articleInfo) *hab {
-	ctx := context.Background()
-	ctx, stop := context.WithCancel(ctx)
+// parseListing parses listing pages, starting from the main page, and returns amount of found article urls.
+// It follows pagination up to maxPages pages and stops earlier,
+// if listing page contains articles, which were already seen.
+func (h *hab) parseListing(run *crawlRun) (int, error) {
+	var found int
+	pageUrl := h.parseFunctions.habMainPageUrl
+	visit := h.mainPageVisitor(run)
+	for page := 1; page <= h.parseFunctions.maxPages && pageUrl != ""; page++ {
+		var err error
+		pageUrl, err = h.fillArticlesBuf(pageUrl, page, visit)
+		if err != nil {
+			return found, err
+		}
 
-	return &hab{
-		habType:        habType,
-		parseFunctions: f,
-		interval:       viper.GetDuration("parser.default-interval"),
-		timer:          time.NewTimer(viper.GetDuration("parser.default-interval")),
-		usedArticles:   make(map[string]struct{}),
-		articleUrlsBuf: make([]string, 0),
-		c:              c,
-		ctx:            ctx,
-		stop:           stop,
+		visit = visitPage
+		found += len(h.articleUrlsBuf)
+		if h.sendArticlesFromBufToParse(run) {
+			break
+		}
 	}
+
+	return found, nil
 }
 
-func (h *hab) parseMainPage() {
-	h.fillArticlesBuf()
-	h.sendArticlesFromBufToParse()
+func (h *hab) fillArticlesBuf(pageUrl string, page int, visit pageVisitor) (string, error) {
+	logrus.Infof("statt fill articles buf on %s, page: %d", h.habType, page)
+
+	var nextPageUrl string
+	var err error
+	h.articleUrlsBuf, nextPageUrl, err = h.parseFunctions.parseMainPage(pageUrl, page, h.articleUrlsBuf, visit)
+	return nextPageUrl, err
 }
 
-func (h *hab) fillArticlesBuf() {
-	logrus.Infof("statt fill articles buf on %s, timer: %v", h.habType, h.interval)
-	h.articleUrlsBuf = h.parseFunctions.parseMainPage(h.articleUrlsBuf)
+// sendArticlesFromBufToParse sends new articles from buffer to parse during the run.
+// It returns true, if some of urls were already seen.
+func (h *hab) sendArticlesFromBufToParse(run *crawlRun) bool {
+	links, seen := h.newArticles(h.articleUrlsBuf)
+	h.articleUrlsBuf = h.articleUrlsBuf[:0]
+
+	for _, elem := range links {
+		select {
+		case h.c <- articleInfo{url: elem.url, item: elem.item, habType: h.habType, run: run}:
+			run.articleQueued()
+		case <-h.ctx.Done():
+			return true
+		}
+	}
+
+	return seen
 }
 
-func (h *hab) sendArticlesFromBufToParse() {
-	for _, elem := range h.articleUrlsBuf {
-		if _, ok := h.usedArticles[elem]; ok {
+// newArticles returns links, which are not in cache of seen articles and are not saved in storage,
+// and marks them as seen. Second returned value is true, if some of links were skipped.
+func (h *hab) newArticles(links []articleLink) ([]articleLink, bool) {
+	var seen bool
+	candidates := make([]articleLink, 0, len(links))
+	urls := make([]string, 0, len(links))
+	for _, elem := range links {
+		if h.seenArticles.contains(elem.url) {
+			seen = true
 			continue
 		}
 
-		h.c <- articleInfo{
-			url:     elem,
-			habType: h.habType,
+		candidates = append(candidates, elem)
+		urls = append(urls, elem.url)
+	}
+
+	if len(candidates) == 0 {
+		return nil, seen
+	}
+
+	stored, err := h.storage.GetStoredArticleUrls(urls)
+	if err != nil {
+		logrus.Errorf("failed to get stored articles of %s, error: %v", h.habType, err)
+	}
+
+	fresh := make([]articleLink, 0, len(candidates))
+	for _, elem := range candidates {
+		if h.seenArticles.contains(elem.url) {
+			continue
 		}
+		h.seenArticles.add(elem.url)
 
-		h.usedArticles[elem] = struct{}{}
+		if _, ok := stored[elem.url]; ok {
+			seen = true
+			continue
+		}
+
+		fresh = append(fresh, elem)
 	}
 
-	h.articleUrlsBuf = h.articleUrlsBuf[:0]
+	return fresh, seen
+}
+
+// seedSeenArticles fills cache of seen articles with the last articles of the hab from storage.
+func (h *hab) seedSeenArticles() error {
+	urls, err := h.storage.GetLastArticleUrls(h.habType, h.seenArticles.size)
+	if err != nil {
+		return err
+	}
+
+	for i := len(urls) - 1; i >= 0; i-- {
+		h.seenArticles.add(urls[i])
+	}
+
+	return nil
 }
 
 func (h *hab) setupRoutine() {
@@ -194,8 +350,33 @@ func (h *hab) setupRoutine() {
 		for {
 			select {
 			case <-h.timer.C:
-				h.parseMainPage()
-				h.timer.Reset(h.interval)
+				h.mx.Lock()
+				paused := h.paused
+				h.mx.Unlock()
+				if paused {
+					continue
+				}
+
+				run, err := h.startRun(models.CrawlTriggerSchedule)
+				if errors.Is(err, ErrHabIsStopping) {
+					return
+				}
+
+				if err == nil {
+					h.crawl(run)
+				} else {
+					logrus.Warnf("run of %s is already going, skip scheduled run", h.habType)
+				}
+
+				h.mx.Lock()
+				h.lastRun = time.Now()
+				if !h.paused {
+					h.nextRun = h.schedule.next(h.lastRun)
+					h.resetTimer(time.Until(h.nextRun))
+				}
+				h.mx.Unlock()
+
+				_ = h.saveState()
 
 			case <-h.ctx.Done():
 				return
@@ -204,10 +385,71 @@ func (h *hab) setupRoutine() {
 	}()
 }
 
+// stopRoutine stops timer of the hab and cancels sending of its articles to parse.
+// Context is canceled under the lock, so that no run is started after that, see startRun.
 func (h *hab) stopRoutine() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.timer.Stop()
 	h.stop()
 }
 
-func (h *hab) changeParseInterval(interval time.Duration) {
-	h.interval = interval
+// pause stops timer of the hab, returns false if hab is already paused.
+func (h *hab) pause() bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.paused {
+		return false
+	}
+
+	h.paused = true
+	h.timer.Stop()
+	return true
+}
+
+// resume restarts timer of the hab, returns false if hab is not paused.
+func (h *hab) resume() bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if !h.paused {
+		return false
+	}
+
+	h.paused = false
+	h.nextRun = h.schedule.next(time.Now())
+	h.resetTimer(time.Until(h.nextRun))
+	return true
+}
+
+// changeSchedule replaces schedule of the hab and reschedules the next run, if hab is not paused.
+// It returns time of the next run, which is zero, if hab is paused.
+func (h *hab) changeSchedule(s schedule) time.Time {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.schedule = s
+	h.overridden = true
+	if h.paused {
+		return time.Time{}
+	}
+
+	h.nextRun = s.next(time.Now())
+	h.resetTimer(time.Until(h.nextRun))
+	return h.nextRun
+}
+
+// resetTimer drains timer channel if it is needed and resets timer to d.
+// Must be called with h.mx held.
+func (h *hab) resetTimer(d time.Duration) {
+	if !h.timer.Stop() {
+		select {
+		case <-h.timer.C:
+		default:
+		}
+	}
+
+	h.timer.Reset(d)
 }
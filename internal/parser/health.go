@@ -0,0 +1,223 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+	"sync"
+	"testTask/internal/models"
+	"time"
+)
+
+const (
+	fieldTitle       = "title"
+	fieldUsername    = "username"
+	fieldUsernameUrl = "usernameUrl"
+	fieldPublishDate = "publishDate"
+	fieldBody        = "body"
+	fieldTags        = "tags"
+)
+
+// habHealth tracks how many article urls were found in the recent runs of the hab
+// and how often every expected field was extracted from the recent articles.
+type habHealth struct {
+	habType string
+	fields  []string
+
+	mx        sync.Mutex
+	runs      []int
+	articles  []map[string]bool
+	status    string
+	reasons   []string
+	checkedAt time.Time
+}
+
+func newHabHealth(habType string, fields []string) *habHealth {
+	return &habHealth{
+		habType:  habType,
+		fields:   fields,
+		runs:     make([]int, 0),
+		articles: make([]map[string]bool, 0),
+		status:   models.HabHealthUnknown,
+	}
+}
+
+// expectedFields returns fields, which must be extracted from every article of the hab.
+// Feed items always have title and date, other fields are expected only if they are parsed from the article page.
+func expectedFields(def models.HabDefinition) []string {
+	if def.Source == models.HabSourceFeed && !def.FeedFallback {
+		return []string{fieldTitle, fieldPublishDate}
+	}
+
+	fields := []string{fieldTitle, fieldUsername, fieldUsernameUrl}
+	if !def.Fields.PublishDate.IsEmpty() || def.Source == models.HabSourceFeed {
+		fields = append(fields, fieldPublishDate)
+	}
+
+	if !def.Fields.Body.IsEmpty() {
+		fields = append(fields, fieldBody)
+	}
+
+	if !def.Fields.Tags.IsEmpty() {
+		fields = append(fields, fieldTags)
+	}
+
+	return fields
+}
+
+// recordRun saves amount of article urls found during the run of the hab.
+func (hh *habHealth) recordRun(urls int) {
+	hh.mx.Lock()
+	defer hh.mx.Unlock()
+
+	hh.runs = append(hh.runs, urls)
+	if window := max(viper.GetInt("parser.health.runs-window"), 1); len(hh.runs) > window {
+		hh.runs = hh.runs[len(hh.runs)-window:]
+	}
+
+	hh.check()
+}
+
+// recordArticle saves which of the expected fields were extracted from the article.
+func (hh *habHealth) recordArticle(article *models.ArticleData) {
+	extracted := map[string]bool{
+		fieldTitle:       article.Title != "",
+		fieldUsername:    article.Username != "",
+		fieldUsernameUrl: article.UsernameUrl != "",
+		fieldPublishDate: !article.PublishData.IsZero(),
+		fieldBody:        article.BodyHtml != "",
+		fieldTags:        len(article.Tags) != 0,
+	}
+
+	hh.mx.Lock()
+	defer hh.mx.Unlock()
+
+	hh.articles = append(hh.articles, extracted)
+	if window := max(viper.GetInt("parser.health.articles-window"), 1); len(hh.articles) > window {
+		hh.articles = hh.articles[len(hh.articles)-window:]
+	}
+
+	hh.check()
+}
+
+// fieldRates returns share of the recent articles, from which every expected field was extracted.
+// Must be called with hh.mx held.
+func (hh *habHealth) fieldRates() map[string]float64 {
+	rates := make(map[string]float64, len(hh.fields))
+	if len(hh.articles) == 0 {
+		return rates
+	}
+
+	for _, field := range hh.fields {
+		var extracted int
+		for _, article := range hh.articles {
+			if article[field] {
+				extracted++
+			}
+		}
+
+		rates[field] = float64(extracted) / float64(len(hh.articles))
+	}
+
+	return rates
+}
+
+// check updates status of the hab. Hab is degraded, if the last run found less than parser.health.min-urls urls,
+// or if some field was extracted from less than parser.health.min-field-rate of the recent articles.
+// Field rates are checked only after parser.health.min-articles articles are parsed.
+// When hab becomes degraded, alert is logged. Must be called with hh.mx held.
+func (hh *habHealth) check() {
+	reasons := make([]string, 0)
+
+	if len(hh.runs) != 0 {
+		minUrls := viper.GetInt("parser.health.min-urls")
+		if urls := hh.runs[len(hh.runs)-1]; urls < minUrls {
+			reasons = append(reasons, fmt.Sprintf("last run found %d article urls, expected at least %d", urls, minUrls))
+		}
+	}
+
+	if len(hh.articles) >= viper.GetInt("parser.health.min-articles") {
+		minRate := viper.GetFloat64("parser.health.min-field-rate")
+		rates := hh.fieldRates()
+		for _, field := range hh.fields {
+			if rates[field] < minRate {
+				reasons = append(reasons, fmt.Sprintf("%s is extracted from %.0f%% of recent articles, expected at least %.0f%%",
+					field, rates[field]*100, minRate*100))
+			}
+		}
+	}
+
+	status := models.HabHealthHealthy
+	if len(reasons) != 0 {
+		status = models.HabHealthDegraded
+	}
+
+	if status == models.HabHealthDegraded && hh.status != models.HabHealthDegraded {
+		logrus.WithFields(logrus.Fields{
+			"alert": "hab-degraded",
+			"hab":   hh.habType,
+		}).Errorf("ALERT: hab %s is degraded: %s", hh.habType, strings.Join(reasons, "; "))
+	}
+
+	if status == models.HabHealthHealthy && hh.status == models.HabHealthDegraded {
+		logrus.Infof("hab %s is healthy again", hh.habType)
+	}
+
+	hh.status = status
+	hh.reasons = reasons
+	hh.checkedAt = time.Now()
+}
+
+func (hh *habHealth) report() models.HabHealth {
+	hh.mx.Lock()
+	defer hh.mx.Unlock()
+
+	health := models.HabHealth{
+		HabType:    hh.habType,
+		Status:     hh.status,
+		Reasons:    append([]string(nil), hh.reasons...),
+		RecentRuns: append([]int(nil), hh.runs...),
+		Articles:   len(hh.articles),
+		FieldRates: hh.fieldRates(),
+		CheckedAt:  hh.checkedAt,
+	}
+
+	if len(hh.runs) != 0 {
+		health.LastRunUrls = hh.runs[len(hh.runs)-1]
+	}
+
+	return health
+}
+
+// GetHabsHealth returns parse health of the hab, or of all habs, if habType is empty.
+// If habType is not exist in habs, GetHabsHealth returns an error.
+func (p *Parser) GetHabsHealth(habType string) ([]models.HabHealth, error) {
+	if habType != "" {
+		h, ok := p.getHab(habType)
+		if !ok {
+			return nil, ErrHabIsNotExist
+		}
+
+		return []models.HabHealth{h.health.report()}, nil
+	}
+
+	p.mx.RLock()
+	habs := make([]*hab, 0, len(p.habs))
+	for _, h := range p.habs {
+		habs = append(habs, h)
+	}
+	p.mx.RUnlock()
+
+	health := make([]models.HabHealth, 0, len(habs))
+	for _, h := range habs {
+		health = append(health, h.health.report())
+	}
+
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].HabType < health[j].HabType
+	})
+
+	return health, nil
+}
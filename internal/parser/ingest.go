@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"testTask/internal/models"
+)
+
+// IngestArticle parses article with url and stores it right away. Article is parsed by the hab habType,
+// or, if habType is empty, by the hab matching url, see matchHab. If article is not valid,
+// IngestArticle returns result with all validation errors and article is not stored.
+func (p *Parser) IngestArticle(url string, habType string) models.IngestResult {
+	url = normalizeUrl(url)
+	result := models.IngestResult{Url: url}
+
+	h, err := p.ingestHab(url, habType)
+	if err != nil {
+		result.Errors = []string{err.Error()}
+		return result
+	}
+
+	if h.parseFunctions.itemRequired {
+		result.Errors = []string{ErrFeedItemIsRequired.Error()}
+		return result
+	}
+
+	article, err := h.parseFunctions.parseArticlePage(url, nil)
+	if err != nil {
+		result.Errors = []string{err.Error()}
+		return result
+	}
+
+	for _, err = range articleErrors(article, h.parseFunctions.authorIsOptional) {
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	if len(result.Errors) != 0 {
+		return result
+	}
+
+	article.Id, err = p.storage.PutArticle(article)
+	if err != nil {
+		result.Errors = []string{err.Error()}
+		return result
+	}
+
+	h.seenArticles.add(url)
+	result.Article = article
+	return result
+}
+
+// IngestArticles parses and stores articles one by one with IngestArticle.
+func (p *Parser) IngestArticles(urls []string, habType string) []models.IngestResult {
+	results := make([]models.IngestResult, 0, len(urls))
+	for _, url := range urls {
+		results = append(results, p.IngestArticle(url, habType))
+	}
+
+	return results
+}
+
+func (p *Parser) ingestHab(articleUrl string, habType string) (*hab, error) {
+	if articleUrl == "" {
+		return nil, ErrUrlIsEmpty
+	}
+
+	if habType != "" {
+		h, ok := p.getHab(habType)
+		if !ok {
+			return nil, ErrHabIsNotExist
+		}
+
+		return h, nil
+	}
+
+	return p.matchHab(articleUrl)
+}
+
+// matchHab returns hab, which article pattern matches url, or, if there is no such hab,
+// hab with the same domain as url. If several habs match, the first one by habType is returned.
+func (p *Parser) matchHab(articleUrl string) (*hab, error) {
+	u, err := url.Parse(articleUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mx.RLock()
+	habs := make([]*hab, 0, len(p.habs))
+	for _, h := range p.habs {
+		habs = append(habs, h)
+	}
+	p.mx.RUnlock()
+
+	sort.Slice(habs, func(i, j int) bool {
+		return habs[i].habType < habs[j].habType
+	})
+
+	for _, h := range habs {
+		if h.articlePattern != nil && h.articlePattern.MatchString(articleUrl) {
+			return h, nil
+		}
+	}
+
+	host := strings.TrimPrefix(u.Host, "www.")
+	for _, h := range habs {
+		for _, domain := range h.domains {
+			if strings.TrimPrefix(strings.ToLower(domain), "www.") == host {
+				return h, nil
+			}
+		}
+	}
+
+	return nil, ErrHabIsNotFoundForUrl
+}
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"testTask/internal/models"
+	"time"
+)
+
+// GetArticleMetrics returns history of the article engagement metrics.
+func (p *Parser) GetArticleMetrics(articleId int) ([]models.ArticleMetrics, error) {
+	return p.storage.GetArticleMetrics(articleId)
+}
+
+// revisitRoutine periodically parses again articles of habs with metrics during their first
+// parser.metrics.revisit-period and saves metrics snapshots. Interval between snapshots of the article
+// starts from parser.metrics.first-interval and doubles after every snapshot.
+func (p *Parser) revisitRoutine() {
+	ticker := time.NewTicker(viper.GetDuration("parser.metrics.check-interval"))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.revisitArticles()
+
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Parser) revisitArticles() {
+	habs := make(map[string]*hab)
+	habTypes := make([]string, 0)
+
+	p.mx.RLock()
+	for habType, h := range p.habs {
+		if h.parseFunctions.hasMetrics {
+			habs[habType] = h
+			habTypes = append(habTypes, habType)
+		}
+	}
+	p.mx.RUnlock()
+
+	if len(habTypes) == 0 {
+		return
+	}
+
+	since := time.Now().Add(-viper.GetDuration("parser.metrics.revisit-period"))
+	articles, err := p.storage.GetArticlesForRevisit(since, viper.GetDuration("parser.metrics.first-interval"),
+		habTypes, viper.GetInt("parser.metrics.batch-size"))
+	if err != nil {
+		logrus.Errorf("failed to get articles for revisit, error: %v", err)
+		return
+	}
+
+	for _, article := range articles {
+		if p.ctx.Err() != nil {
+			return
+		}
+
+		data, err := habs[article.HabType].parseFunctions.parseArticlePage(article.Url, nil)
+		if err != nil {
+			logrus.Errorf("failed to parse metrics of %s, error: %v", article.Url, err)
+			continue
+		}
+
+		if data.Metrics == nil {
+			continue
+		}
+
+		err = p.storage.PutArticleMetrics(article.Id, *data.Metrics)
+		if err != nil {
+			logrus.Errorf("failed to put metrics of %s, error: %v", article.Url, err)
+		}
+	}
+}
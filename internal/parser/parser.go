@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/viper"
 	"sync"
 	"testTask/internal/database"
+	"testTask/internal/fetcher"
 	"testTask/internal/models"
 	"time"
 )
@@ -19,125 +20,317 @@ var (
 	ErrHabIsEmpty          = errors.New("habType is empty")
 	ErrHabIsNotExist       = errors.New("such hab does not exist")
 	ErrHabIsAlreadyParsing = errors.New("hab is already parsing")
+	ErrHabIsAlreadyStopped = errors.New("hab is already stopped")
+
+	ErrHabIsAlreadyExist        = errors.New("hab with such habType already exists")
+	ErrMainPageUrlIsEmpty       = errors.New("mainPageUrl is empty")
+	ErrLinkSelectorIsEmpty      = errors.New("linkSelector is empty")
+	ErrFieldSelectorIsEmpty     = errors.New("title, username and usernameUrl selectors must be specified")
+	ErrIntervalIsNotPositive    = errors.New("interval must be positive")
+	ErrScheduleIsEmpty          = errors.New("schedule is empty")
+	ErrScheduleIsAmbiguous      = errors.New("only one of interval, cron and windows must be specified")
+	ErrScheduleHasNoRuns        = errors.New("schedule has no runs in the future")
+	ErrCronIsInvalid            = errors.New("cron expression is invalid, it must have 5 fields: minute, hour, day of month, month and day of week")
+	ErrTimeWindowIsInvalid      = errors.New("time window must be in the form [days] hh:mm-hh:mm interval")
+	ErrUnknownSource            = errors.New("unknown source, available sources: html, feed, sitemap")
+	ErrMaxPagesIsNegative       = errors.New("maxPages must not be negative")
+	ErrPaginationIsNotSpecified = errors.New("nextSelector or urlTemplate must be specified to parse more than one page")
+
+	ErrSelectorAndXPathAreSpecified = errors.New("only one of selector and xpath must be specified for the field")
+	ErrParallelismIsNegative        = errors.New("parallelism must not be negative")
+	ErrDelayIsNegative              = errors.New("delay must not be negative")
+	ErrTimeoutIsNotPositive         = errors.New("timeout must be positive")
+	ErrMaxBodySizeIsNegative        = errors.New("maxBodySize must not be negative")
+	ErrUnknownProxyRotation         = errors.New("unknown proxy rotation, available rotations: round-robin, random")
+	ErrUnknownProxyScheme           = errors.New("unknown proxy scheme, available schemes: http, https, socks5, socks5h")
+	ErrCookieIsInvalid              = errors.New("cookie must be in the name=value form")
+
+	ErrBackfillLimitIsNotSpecified = errors.New("pages or until must be specified")
+	ErrBackfillIsAlreadyRunning    = errors.New("backfill of the hab is already running")
+	ErrBackfillIsNotExist          = errors.New("backfill of the hab does not exist")
+	ErrBackfillIsNotSupported      = errors.New("backfill is not supported for habs with sitemap source")
+	ErrBackfillIsNotFailed         = errors.New("backfill of the hab is not failed")
+
+	ErrDeadLetterIsNotExist = errors.New("dead letter with such url does not exist")
+	ErrPageIsNotModified    = errors.New("page is not modified")
+	ErrFeedItemIsRequired   = errors.New("articles of the feed hab without feedFallback can be parsed only from the feed")
+
+	ErrRunIsAlreadyGoing = errors.New("run of the hab is already going")
+	ErrHabIsStopping     = errors.New("hab is stopping")
+	ErrRunIsNotExist     = errors.New("run with such id does not exist")
+
+	ErrLimitIsNotPositive = errors.New("limit must be positive")
+
+	ErrHabIsNotFoundForUrl = errors.New("there is no hab matching the url")
 )
 
 type Parser struct {
+	mx          sync.RWMutex
 	habs        map[string]*hab
+	backfills   map[string]struct{}
+	parsing     bool
 	articlesBuf *articlesBuf
-	storage     *database.Database
+	flushMx     sync.Mutex
+	storage     Storage
+	runsSaver   *runsSaver
+	deadLetters *deadLettersBuf
+	collectors  *collectors
 
 	ctx              context.Context
 	stop             context.CancelFunc
 	goroutinesAmount int
-	c                <-chan articleInfo
+	c                chan articleInfo
+	workers          sync.WaitGroup
+	routines         sync.WaitGroup
 }
 
-// NewParser inits new Parser object
-func NewParser(db *database.Database) (*Parser, error) {
+// NewParser inits new Parser object.
+// Habs from the habs section of configuration are saved in storage, if they are not there yet,
+// or their saved definitions are replaced, if they were changed in configuration.
+// After that all habs, except deleted, are built from definitions saved in storage
+// and their scheduler state is restored. All pages are downloaded with pageFetcher.
+func NewParser(db Storage, pageFetcher fetcher.Fetcher) (*Parser, error) {
 	c := make(chan articleInfo)
+	cl, err := newCollectors(pageFetcher)
+	if err != nil {
+		return nil, err
+	}
+
+	defs, err := loadHabDefinitions()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range defs {
+		err = db.GetHabInfo(def.HabType)
+		if errors.Is(err, database.ErrRowNotExist) {
+			err = db.PutHab(def)
+		} else if err == nil {
+			var updated bool
+			updated, err = db.UpdateHabDefinition(def)
+			if updated {
+				logrus.Infof("definition of %s is changed in configuration, update it", def.HabType)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	normalized, err := db.NormalizeArticleUrls(normalizeUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if normalized != 0 {
+		logrus.Infof("urls of %d stored articles are normalized", normalized)
+	}
+
+	interrupted, err := db.InterruptCrawlRuns()
+	if err != nil {
+		return nil, err
+	}
 
+	if interrupted != 0 {
+		logrus.Warnf("%d runs were not finished before the previous stop, mark them as interrupted", interrupted)
+	}
+
+	habsInfo, err := db.GetHabsInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := newRunsSaver(db)
 	habs := make(map[string]*hab)
-	for habType, f := range habsMap {
-		habs[habType] = newHab(habType, f, c)
+	for _, info := range habsInfo {
+		if info.State.Status == models.HabStatusDeleted {
+			continue
+		}
+
+		if info.Definition == nil {
+			logrus.Warnf("hab %s has no definition, skip it", info.HabType)
+			continue
+		}
+
+		h, register, err := newHabFromDefinition(*info.Definition, c, db, runs, cl)
+		if err == nil {
+			err = register()
+		}
+
+		if err != nil {
+			logrus.Errorf("failed to build hab %s, error: %v", info.HabType, err)
+			continue
+		}
+
+		h.restoreState(info.State)
+		err = h.seedSeenArticles()
+		if err != nil {
+			logrus.Errorf("failed to seed seen articles of %s, error: %v", info.HabType, err)
+		}
+
+		habs[info.HabType] = h
 	}
 
 	ctx := context.Background()
 	ctx, stop := context.WithCancel(ctx)
 
 	p := &Parser{
-		articlesBuf: &articlesBuf{
-			buf: make([]*models.ArticleData, 0),
-			mx:  sync.Mutex{},
-		},
+		articlesBuf:      newArticlesBuf(viper.GetInt("parser.load-data-batch-size")),
 		habs:             habs,
+		backfills:        make(map[string]struct{}),
 		storage:          db,
+		runsSaver:        runs,
+		deadLetters:      &deadLettersBuf{},
+		collectors:       cl,
 		goroutinesAmount: viper.GetInt("parser.goroutines-amount"),
 		c:                c,
 		ctx:              ctx,
 		stop:             stop,
 	}
 
-	go func() {
-		for {
-			time.Sleep(viper.GetDuration("parser.load-data-interval"))
-			logrus.Info("start put data in table")
-			_ = p.putArticleInTable()
-		}
-	}()
+	p.goRoutine(p.flushRoutine)
 
 	return p, nil
 }
 
-// Parse starts parsing habs from habsMap.
+// Parse starts parsing habs.
 // It allocates new routine for every hab to parse it`s main page.
-// Also, Parse setups routines for processing routines parsing.
+// Also, Parse setups routines for processing routines parsing, resumes unfinished backfills,
+// starts collecting metrics of the articles and parses again articles, which dates were reset.
 func (p *Parser) Parse() {
+	p.mx.Lock()
 	for _, h := range p.habs {
 		h.setupRoutine()
 	}
+	p.parsing = true
+	p.mx.Unlock()
 
+	p.workers.Add(p.goroutinesAmount)
 	for i := 0; i < p.goroutinesAmount; i++ {
-		go p.processRoutine(p.ctx)
+		go func() {
+			defer p.workers.Done()
+			p.processRoutine(p.ctx)
+		}()
 	}
+
+	p.resumeBackfills()
+	p.goRoutine(p.revisitRoutine)
+	p.goRoutine(p.reparseConvertedArticles)
+}
+
+// goRoutine runs background routine, which returns when parser is stopped. Stop waits for such routines.
+func (p *Parser) goRoutine(routine func()) {
+	p.routines.Add(1)
+	go func() {
+		defer p.routines.Done()
+		routine()
+	}()
 }
 
 // StopParsingHab stops timer of main page parser.
 // To use this method you should specify habType of the routine, that you want to stop.
-// If habType is not located in habsMap, StopParsingHab returns an error.
+// If habType is not located in habs or is already stopped, StopParsingHab returns an error.
+// Paused state is saved in storage and is kept after restart.
 func (p *Parser) StopParsingHab(habType string) error {
-	h, ok := p.habs[habType]
+	h, ok := p.getHab(habType)
 	if !ok {
 		return ErrHabIsNotExist
 	}
 
-	h.timer.Stop()
-	return nil
+	if !h.pause() {
+		return ErrHabIsAlreadyStopped
+	}
+
+	return h.saveState()
 }
 
 // AddHabForParsing method let routine resume parsing habType, who previously was stopped.
 // If habType is already parsing, AddHabForParsing returns an error.
-// If habType is not exist in habsMap, it also returns an error
+// If habType is not exist in habs, it also returns an error
 func (p *Parser) AddHabForParsing(habType string) error {
-	h, ok := p.habs[habType]
+	h, ok := p.getHab(habType)
 	if !ok {
 		return ErrHabIsNotExist
 	}
 
-	if !h.timer.Stop() {
-		h.timer.Reset(h.interval)
-		return nil
+	if !h.resume() {
+		return ErrHabIsAlreadyParsing
 	}
 
-	return ErrHabIsAlreadyParsing
+	return h.saveState()
 }
 
-// ChangeIntervalForHab is used to change parse interval for current hab.
-// If habType is not exist in habsMap, it returns an error.
-func (p *Parser) ChangeIntervalForHab(habType string, interval string) error {
-	_, ok := p.habs[habType]
+// ChangeIntervalForHab is used to change parse schedule for current hab. Schedule can be an interval,
+// a cron expression or a list of time windows, see parseSchedule. It returns time of the next run,
+// which is zero, if hab is paused. If habType is not exist in habs, it returns an error.
+func (p *Parser) ChangeIntervalForHab(habType string, interval string) (time.Time, error) {
+	h, ok := p.getHab(habType)
 	if !ok {
-		return ErrHabIsNotExist
+		return time.Time{}, ErrHabIsNotExist
 	}
 
-	t, err := time.ParseDuration(interval)
+	s, err := parseSchedule(interval)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	nextRun := h.changeSchedule(s)
+	return nextRun, h.saveState()
+}
+
+// RegisterHab validates hab definition, saves it in storage and starts parsing the hab.
+// If hab with such habType already exists, RegisterHab returns an error.
+func (p *Parser) RegisterHab(def models.HabDefinition) error {
+	err := validateHabDefinition(def)
 	if err != nil {
 		return err
 	}
 
-	p.habs[habType].changeParseInterval(t)
-	return nil
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if _, ok := p.habs[def.HabType]; ok {
+		return ErrHabIsAlreadyExist
+	}
+
+	h, register, err := newHabFromDefinition(def, p.c, p.storage, p.runsSaver, p.collectors)
+	if err != nil {
+		return err
+	}
+
+	err = register()
+	if err != nil {
+		return err
+	}
+
+	err = p.storage.PutHab(def)
+	if err != nil {
+		return err
+	}
+
+	p.habs[def.HabType] = h
+	if p.parsing {
+		h.setupRoutine()
+	}
+
+	return h.saveState()
 }
 
 // DeleteHab is used to delete hab from parsing.
-// WARNING! DeleteHab deletes hab from parsing forever and also delete all information about hab from storage.
+// WARNING! DeleteHab deletes hab from parsing forever and also delete all articles of the hab from storage,
+// hab is only marked as deleted and can be registered again with RegisterHab.
 // To stop parsing hab for some time you should use StopParsingHab.
 func (p *Parser) DeleteHab(habType string) ([]int, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
 	h, ok := p.habs[habType]
 	if !ok {
 		return nil, ErrHabIsNotExist
 	}
 
-	h.stop()
+	h.stopRoutine()
 	ids, err := p.storage.DeleteHab(habType)
 	if err != nil {
 		return nil, err
@@ -148,72 +341,292 @@ func (p *Parser) DeleteHab(habType string) ([]int, error) {
 	return ids, nil
 }
 
+func (p *Parser) getHab(habType string) (*hab, bool) {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	h, ok := p.habs[habType]
+	return h, ok
+}
+
+// articleInfo is a task for processing routines.
+// If parsed is not nil, parsed article is also sent to it, when article is parsed or moved to dead letters.
+// lastmod is a modification time of the article from sitemap, attempt is an amount of failed attempts to parse it.
+// item is the feed item of the article, if article was found in the feed.
 type articleInfo struct {
 	url     string
+	item    *models.ArticleData
 	habType string
+	lastmod time.Time
+	attempt int
+	run     *crawlRun
+	parsed  chan<- *models.ArticleData
+}
+
+// Stop stops parsing: timers of the habs are stopped, routines stop sending articles to parse,
+// processing routines parse articles left in the channel and buffers are saved to storage.
+// If routines are not finished before ctx is done, buffer is saved anyway and Stop returns an error.
+func (p *Parser) Stop(ctx context.Context) error {
+	p.mx.Lock()
+	p.parsing = false
+	habs := make([]*hab, 0, len(p.habs))
+	for _, h := range p.habs {
+		habs = append(habs, h)
+	}
+	p.mx.Unlock()
+
+	for _, h := range habs {
+		h.stopRoutine()
+	}
+	p.stop()
+
+	err := wait(ctx, &p.workers)
+	if err == nil {
+		err = wait(ctx, &p.routines)
+	}
+
+	for _, h := range habs {
+		if err != nil {
+			break
+		}
+
+		err = wait(ctx, &h.crawls)
+	}
+
+	if err != nil {
+		logrus.Errorf("failed to wait for parsing routines, error: %v", err)
+	}
+
+	for _, h := range habs {
+		h.interruptRuns()
+	}
+
+	logrus.Info("put data in table before stop")
+	return errors.Join(err, p.flush())
+}
+
+// wait waits for wg until ctx is done.
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
+// processRoutine parses articles from the channel until ctx is done,
+// after that it parses articles, which are still being sent to the channel, and returns.
 func (p *Parser) processRoutine(ctx context.Context) {
 	for {
 		select {
 		case val := <-p.c:
-			article := habsMap[val.habType].parseArticlePage(val.url)
-			p.articlesBuf.appendBuf(article)
+			p.processArticle(val)
 
 		case <-ctx.Done():
+			for {
+				select {
+				case val := <-p.c:
+					p.processArticle(val)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// processArticle parses article and puts it to the buffer. If article page was not downloaded
+// or article is not valid, parsing is retried later. Article is recorded in health of the hab
+// once, when it is parsed or moved to dead letters.
+func (p *Parser) processArticle(val articleInfo) {
+	h, ok := p.getHab(val.habType)
+	if !ok {
+		return
+	}
+
+	article, err := h.parseFunctions.parseArticlePage(val.url, val.item)
+	if err == nil {
+		err = p.validateArticle(article)
+	}
+
+	if err != nil {
+		p.retryArticle(val, article, err)
+		return
+	}
+
+	h.health.recordArticle(article)
+	article.Lastmod = val.lastmod
+	p.articlesBuf.appendBuf(article)
+	if val.run != nil {
+		val.run.articleParsed()
+	}
+
+	if val.parsed != nil {
+		val.parsed <- article
+	}
+}
+
+// validateArticle checks that all required fields of the article are filled.
+func (p *Parser) validateArticle(article *models.ArticleData) error {
+	return errors.Join(p.articleErrors(article)...)
+}
+
+// articleErrors returns errors for all required fields of the article, which are not filled.
+// Author is not required for feed habs without feed-fallback, because feed items often do not have it.
+func (p *Parser) articleErrors(article *models.ArticleData) []error {
+	h, ok := p.getHab(article.HabType)
+	authorIsOptional := ok && h.parseFunctions.authorIsOptional
+
+	return articleErrors(article, authorIsOptional)
+}
+
+func articleErrors(article *models.ArticleData, authorIsOptional bool) []error {
+	errs := make([]error, 0)
+	if article.Url == "" {
+		errs = append(errs, ErrUrlIsEmpty)
+	}
+
+	if article.Title == "" {
+		errs = append(errs, ErrTitleIsEmpty)
+	}
+
+	if article.Username == "" && !authorIsOptional {
+		errs = append(errs, ErrUsernameIsEmpty)
+	}
+
+	if article.UsernameUrl == "" && !authorIsOptional {
+		errs = append(errs, ErrUsernameUrlIsEmpty)
+	}
+
+	if article.HabType == "" {
+		errs = append(errs, ErrHabIsEmpty)
+	}
+
+	return errs
+}
+
+// flushRoutine saves buffers to storage, when articles buffer reaches parser.load-data-batch-size articles
+// or parser.load-data-interval passes since the previous save, whichever comes first.
+func (p *Parser) flushRoutine() {
+	interval := viper.GetDuration("parser.load-data-interval")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.articlesBuf.full:
+			ticker.Reset(interval)
+		case <-p.ctx.Done():
 			return
 		}
+
+		_ = p.flush()
 	}
 }
 
+// flush saves buffered articles, dead letters and progress of the runs to storage.
+func (p *Parser) flush() error {
+	return errors.Join(p.putArticleInTable(), p.putDeadLetters(), p.runsSaver.save())
+}
+
+// putArticleInTable saves buffered articles to storage. Buffer is swapped under the lock,
+// so processing routines are not blocked while articles are saved. Sitemaps of the done runs are taken
+// before the buffer, so all their articles are in it, and their lastmods are saved only if articles are saved.
 func (p *Parser) putArticleInTable() error {
-	p.articlesBuf.mx.Lock()
+	p.flushMx.Lock()
+	defer p.flushMx.Unlock()
 
-	for _, article := range p.articlesBuf.buf {
-		if article.Url == "" {
-			logrus.Error(ErrUrlIsEmpty)
-			continue
-		}
+	sitemaps := p.runsSaver.takeSitemaps()
+	err := p.putArticles(p.articlesBuf.swap())
+	if err != nil {
+		return err
+	}
 
-		if article.Title == "" {
-			logrus.Error(ErrTitleIsEmpty)
-			continue
-		}
+	p.runsSaver.saveSitemapLastmods(sitemaps)
+	return nil
+}
 
-		if article.Username == "" {
-			logrus.Error(ErrUsernameIsEmpty)
+// putArticles saves valid articles in one transaction. If transaction fails, articles are saved one by one,
+// so that one broken article does not prevent saving the others.
+func (p *Parser) putArticles(buf []*models.ArticleData) error {
+	articles := make([]*models.ArticleData, 0)
+	for _, article := range buf {
+		if err := p.validateArticle(article); err != nil {
+			logrus.Error(err)
 			continue
 		}
 
-		if article.UsernameUrl == "" {
-			logrus.Error(ErrUsernameUrlIsEmpty)
-			continue
-		}
+		articles = append(articles, article)
+	}
 
-		if article.HabType == "" {
-			logrus.Error(ErrHabIsEmpty)
-			continue
-		}
+	if len(articles) == 0 {
+		return nil
+	}
+
+	logrus.Infof("start put %d articles in table", len(articles))
+	_, err := p.storage.PutArticles(articles)
+	if err == nil {
+		return nil
+	}
 
-		_, err := p.storage.PutArticle(article.Url, article.Username, article.UsernameUrl, article.Title, article.PublishData, article.HabType)
+	logrus.Errorf("failed to put articles in one transaction, put them one by one, error: %v", err)
+	errs := make([]error, 0)
+	for _, article := range articles {
+		_, err = p.storage.PutArticle(article)
 		if err != nil {
-			logrus.Errorf("failed to put data, error: %v", err)
+			logrus.Errorf("failed to put data, URL: %s, error: %v", article.Url, err)
+			errs = append(errs, err)
 		}
 	}
 
-	p.articlesBuf.buf = p.articlesBuf.buf[:0]
-	p.articlesBuf.mx.Unlock()
-
-	return nil
+	return errors.Join(errs...)
 }
 
+// articlesBuf keeps parsed articles until they are saved to storage.
+// When buffer reaches size articles, it is signaled through full.
 type articlesBuf struct {
-	buf []*models.ArticleData
-	mx  sync.Mutex
+	buf  []*models.ArticleData
+	mx   sync.Mutex
+	size int
+	full chan struct{}
+}
+
+func newArticlesBuf(size int) *articlesBuf {
+	return &articlesBuf{
+		buf:  make([]*models.ArticleData, 0, max(size, 0)),
+		size: size,
+		full: make(chan struct{}, 1),
+	}
 }
 
 func (a *articlesBuf) appendBuf(data *models.ArticleData) {
 	a.mx.Lock()
 	a.buf = append(a.buf, data)
+	full := a.size > 0 && len(a.buf) >= a.size
 	a.mx.Unlock()
+
+	if full {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// swap returns buffered articles and replaces them with empty buffer.
+func (a *articlesBuf) swap() []*models.ArticleData {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	buf := a.buf
+	a.buf = make([]*models.ArticleData, 0, max(a.size, 0))
+	return buf
 }
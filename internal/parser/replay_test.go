@@ -0,0 +1,536 @@
+package parser
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testTask/internal/database"
+	"testTask/internal/fetcher"
+	"testTask/internal/models"
+	"testing"
+	"time"
+)
+
+// fixturesDir contains responses in the format of fetcher.Recorder. Pages are trimmed copies of habr and skillbox pages,
+// which keep markup matched by selectors of the habs from configuration.yaml.
+const fixturesDir = "testdata/fixtures"
+
+// replayHab returns definition of the hab from configuration.yaml and factory of its collectors,
+// which serve pages from fixturesDir without network.
+func replayHab(t *testing.T, habType string) (models.HabDefinition, collectorFactory) {
+	t.Helper()
+
+	viper.Reset()
+	viper.SetConfigFile("../../configuration.yaml")
+	err := viper.ReadInConfig()
+	if err != nil {
+		t.Fatalf("failed to read configuration, error: %v", err)
+	}
+
+	defs, err := loadHabDefinitions()
+	if err != nil {
+		t.Fatalf("failed to load habs, error: %v", err)
+	}
+
+	idx := slices.IndexFunc(defs, func(def models.HabDefinition) bool {
+		return def.HabType == habType
+	})
+	if idx == -1 {
+		t.Fatalf("hab %s is not in configuration", habType)
+	}
+
+	cl, err := newCollectors(fetcher.NewReplayer(fixturesDir))
+	if err != nil {
+		t.Fatalf("failed to create collectors, error: %v", err)
+	}
+
+	newCollector, _, err := cl.forHab(defs[idx])
+	if err != nil {
+		t.Fatalf("failed to create collectors of %s, error: %v", habType, err)
+	}
+
+	return defs[idx], newCollector
+}
+
+// replayBody returns body of the recorded response for url.
+func replayBody(t *testing.T, url string) []byte {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := fetcher.NewReplayer(fixturesDir).RoundTrip(request)
+	if err != nil {
+		t.Fatalf("failed to replay %s, error: %v", url, err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func TestParseMainPageReplay(t *testing.T) {
+	tests := []struct {
+		habType  string
+		urls     []string
+		nextPage string
+	}{
+		{
+			habType: "habr",
+			urls: []string{
+				"https://habr.com/ru/articles/805001",
+				"https://habr.com/ru/articles/805002",
+				"https://habr.com/ru/companies/example/articles/805003",
+			},
+			nextPage: "https://habr.com/ru/articles/page2/",
+		},
+		{
+			habType: "skillbox",
+			urls: []string{
+				"https://skillbox.ru/media/code/kak-rabotat-s-gorutinami",
+				"https://skillbox.ru/media/design/chto-takoe-ux",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.habType, func(t *testing.T) {
+			def, newCollector := replayHab(t, tt.habType)
+			f := newHtmlParseFunctions(def, newCollector)
+
+			links, nextPage, err := f.parseMainPage(def.MainPageUrl, 1, nil, visitPage)
+			if err != nil {
+				t.Fatalf("failed to parse main page, error: %v", err)
+			}
+
+			urls := make([]string, 0, len(links))
+			for _, link := range links {
+				urls = append(urls, link.url)
+			}
+
+			if !slices.Equal(urls, tt.urls) {
+				t.Errorf("urls = %v, want %v", urls, tt.urls)
+			}
+
+			if nextPage != tt.nextPage {
+				t.Errorf("next page = %q, want %q", nextPage, tt.nextPage)
+			}
+		})
+	}
+}
+
+func TestParseArticlePageReplay(t *testing.T) {
+	tests := []struct {
+		habType  string
+		url      string
+		want     models.ArticleData
+		bodyText []string
+		metrics  *models.ArticleMetrics
+	}{
+		{
+			habType: "habr",
+			url:     "https://habr.com/ru/articles/805001",
+			want: models.ArticleData{
+				Title:       "Как мы переписали планировщик на Go",
+				Username:    "gopher_dev",
+				UsernameUrl: "https://habr.com/ru/users/gopher_dev/",
+				PublishData: time.Date(2024, time.March, 12, 9, 30, 0, 0, time.UTC),
+				Tags:        []string{"go", "программирование", "cron"},
+			},
+			bodyText: []string{
+				"Планировщик запускал задачи по таймеру и терял их при перезапуске.",
+				"ticker := time.NewTicker(time.Minute)",
+				"Теперь состояние хранится в базе данных.",
+			},
+			metrics: &models.ArticleMetrics{Rating: 42, Views: 12000, Bookmarks: 87, Comments: 15},
+		},
+		{
+			habType: "skillbox",
+			url:     "https://skillbox.ru/media/code/kak-rabotat-s-gorutinami",
+			want: models.ArticleData{
+				Title:       "Как работать с горутинами",
+				Username:    "Иван Петров",
+				UsernameUrl: "https://skillbox.ru/media/authors/ivan-petrov/",
+				PublishData: time.Date(2024, time.March, 11, 21, 0, 0, 0, time.UTC),
+				Tags:        []string{"go", "конкурентность"},
+			},
+			bodyText: []string{
+				"Горутины запускаются ключевым словом go.",
+				"Для синхронизации используют каналы и sync.WaitGroup.",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.habType, func(t *testing.T) {
+			def, newCollector := replayHab(t, tt.habType)
+			f := newHtmlParseFunctions(def, newCollector)
+
+			article, err := f.parseArticlePage(tt.url, nil)
+			if err != nil {
+				t.Fatalf("failed to parse article, error: %v", err)
+			}
+
+			if errs := articleErrors(article, false); len(errs) != 0 {
+				t.Errorf("article is not valid: %v", errs)
+			}
+
+			if article.Url != tt.url || article.HabType != tt.habType {
+				t.Errorf("url = %q, hab = %q, want %q, %q", article.Url, article.HabType, tt.url, tt.habType)
+			}
+
+			if article.Title != tt.want.Title || article.Username != tt.want.Username || article.UsernameUrl != tt.want.UsernameUrl {
+				t.Errorf("title = %q, username = %q, username url = %q, want %q, %q, %q",
+					article.Title, article.Username, article.UsernameUrl, tt.want.Title, tt.want.Username, tt.want.UsernameUrl)
+			}
+
+			if !article.PublishData.Equal(tt.want.PublishData) {
+				t.Errorf("publish date = %s, want %s", article.PublishData, tt.want.PublishData)
+			}
+
+			if !slices.Equal(article.Tags, tt.want.Tags) {
+				t.Errorf("tags = %v, want %v", article.Tags, tt.want.Tags)
+			}
+
+			if article.BodyText != strings.Join(tt.bodyText, "\n") {
+				t.Errorf("body text = %q, want %q", article.BodyText, strings.Join(tt.bodyText, "\n"))
+			}
+
+			if strings.Contains(article.BodyHtml, "<script") {
+				t.Errorf("body html is not sanitized: %s", article.BodyHtml)
+			}
+
+			if tt.metrics == nil {
+				if article.Metrics != nil {
+					t.Errorf("metrics = %+v, want nil", article.Metrics)
+				}
+
+				return
+			}
+
+			if article.Metrics == nil {
+				t.Fatal("metrics are not parsed")
+			}
+
+			metrics := *article.Metrics
+			metrics.CollectedAt = time.Time{}
+			if metrics != *tt.metrics {
+				t.Errorf("metrics = %+v, want %+v", metrics, *tt.metrics)
+			}
+		})
+	}
+}
+
+func TestParseFeedReplay(t *testing.T) {
+	def, _ := replayHab(t, "habr")
+	feedUrl := "https://habr.com/ru/rss/articles/"
+
+	articles, nextPage, err := parseFeed(def, feedUrl, replayBody(t, feedUrl))
+	if err != nil {
+		t.Fatalf("failed to parse feed, error: %v", err)
+	}
+
+	if nextPage != "" {
+		t.Errorf("next page = %q, want empty", nextPage)
+	}
+
+	if len(articles) != 2 {
+		t.Fatalf("got %d articles, want 2", len(articles))
+	}
+
+	first := articles[0]
+	if first.Url != "https://habr.com/ru/articles/805001" || first.Title != "Как мы переписали планировщик на Go" || first.Username != "gopher_dev" {
+		t.Errorf("url = %q, title = %q, username = %q", first.Url, first.Title, first.Username)
+	}
+
+	if !first.PublishData.Equal(time.Date(2024, time.March, 12, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("publish date = %s", first.PublishData)
+	}
+
+	if want := []string{"go", "программирование"}; !slices.Equal(first.Tags, want) {
+		t.Errorf("tags = %v, want %v", first.Tags, want)
+	}
+
+	if !strings.HasPrefix(first.BodyText, "Планировщик запускал задачи") {
+		t.Errorf("body text = %q", first.BodyText)
+	}
+
+	// feed items have no author url, so they are valid only if author is optional
+	if errs := articleErrors(first, true); len(errs) != 0 {
+		t.Errorf("article is not valid: %v", errs)
+	}
+
+	second := articles[1]
+	if second.Url != "https://habr.com/ru/articles/805002" || second.Username != "" {
+		t.Errorf("url = %q, username = %q", second.Url, second.Username)
+	}
+
+	if errs := articleErrors(second, true); len(errs) != 0 {
+		t.Errorf("article without author is not valid: %v", errs)
+	}
+}
+
+func TestDecodeSitemapReplay(t *testing.T) {
+	indexUrl := "https://habr.com/sitemap.xml"
+	sitemaps, articles, err := decodeSitemap(indexUrl, replayBody(t, indexUrl))
+	if err != nil {
+		t.Fatalf("failed to decode sitemap index, error: %v", err)
+	}
+
+	wantSitemaps := []sitemapEntry{
+		{loc: "https://habr.com/sitemap/articles-1.xml", lastmod: time.Date(2024, time.March, 12, 7, 5, 0, 0, time.UTC)},
+		{loc: "https://habr.com/sitemap/hubs.xml", lastmod: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if len(articles) != 0 || !slices.EqualFunc(sitemaps, wantSitemaps, sitemapEntryEqual) {
+		t.Errorf("sitemaps = %v, articles = %v, want %v", sitemaps, articles, wantSitemaps)
+	}
+
+	urlsetUrl := "https://habr.com/sitemap/articles-1.xml"
+	sitemaps, articles, err = decodeSitemap(urlsetUrl, replayBody(t, urlsetUrl))
+	if err != nil {
+		t.Fatalf("failed to decode sitemap, error: %v", err)
+	}
+
+	wantArticles := []sitemapEntry{
+		{loc: "https://habr.com/ru/articles/805001/", lastmod: time.Date(2024, time.March, 12, 9, 30, 0, 0, time.UTC)},
+		{loc: "https://habr.com/ru/articles/805002/", lastmod: time.Date(2024, time.March, 12, 10, 5, 0, 0, time.UTC)},
+		{loc: "https://habr.com/ru/articles/805003/"},
+	}
+	if len(sitemaps) != 0 || !slices.EqualFunc(articles, wantArticles, sitemapEntryEqual) {
+		t.Errorf("sitemaps = %v, articles = %v, want %v", sitemaps, articles, wantArticles)
+	}
+}
+
+func sitemapEntryEqual(a, b sitemapEntry) bool {
+	return a.loc == b.loc && a.lastmod.Equal(b.lastmod)
+}
+
+// fakeStorage keeps data of the parser in memory. Methods, which are not used by the tests, panic.
+type fakeStorage struct {
+	Storage
+
+	mx          sync.Mutex
+	habs        map[string]models.HabInfo
+	articles    map[string]*models.ArticleData
+	deadLetters map[string]models.DeadLetter
+	runs        map[string]models.CrawlRun
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		habs:        make(map[string]models.HabInfo),
+		articles:    make(map[string]*models.ArticleData),
+		deadLetters: make(map[string]models.DeadLetter),
+		runs:        make(map[string]models.CrawlRun),
+	}
+}
+
+func (s *fakeStorage) PutHab(def models.HabDefinition) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.habs[def.HabType] = models.HabInfo{
+		HabType:     def.HabType,
+		MainPageUrl: def.MainPageUrl,
+		Definition:  &def,
+		State:       models.HabState{Status: models.HabStatusRunning},
+	}
+
+	return nil
+}
+
+func (s *fakeStorage) GetHabInfo(habType string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.habs[habType]; !ok {
+		return database.ErrRowNotExist
+	}
+
+	return nil
+}
+
+func (s *fakeStorage) GetHabsInfo() ([]models.HabInfo, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	habs := make([]models.HabInfo, 0, len(s.habs))
+	for _, info := range s.habs {
+		habs = append(habs, info)
+	}
+
+	return habs, nil
+}
+
+func (s *fakeStorage) UpdateHabDefinition(models.HabDefinition) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeStorage) PutHabState(habType string, state models.HabState) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	info := s.habs[habType]
+	info.State = state
+	s.habs[habType] = info
+	return nil
+}
+
+func (s *fakeStorage) PutArticle(article *models.ArticleData) (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.articles[article.Url] = article
+	return len(s.articles), nil
+}
+
+func (s *fakeStorage) PutArticles(articles []*models.ArticleData) ([]int, error) {
+	ids := make([]int, 0, len(articles))
+	for _, article := range articles {
+		id, _ := s.PutArticle(article)
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (s *fakeStorage) GetStoredArticleUrls(urls []string) (map[string]struct{}, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	stored := make(map[string]struct{})
+	for _, url := range urls {
+		if _, ok := s.articles[url]; ok {
+			stored[url] = struct{}{}
+		}
+	}
+
+	return stored, nil
+}
+
+func (s *fakeStorage) GetLastArticleUrls(string, int) ([]string, error) {
+	return nil, nil
+}
+
+func (s *fakeStorage) GetArticleUrlsToReparse(string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *fakeStorage) NormalizeArticleUrls(func(string) string) (int, error) {
+	return 0, nil
+}
+
+func (s *fakeStorage) PutDeadLetter(letter models.DeadLetter) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.deadLetters[letter.Url] = letter
+	return nil
+}
+
+func (s *fakeStorage) PutCrawlRun(run models.CrawlRun) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.runs[run.ID] = run
+	return nil
+}
+
+func (s *fakeStorage) InterruptCrawlRuns() (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeStorage) GetBackfills() ([]models.BackfillState, error) {
+	return nil, nil
+}
+
+// TestCrawlReplay runs manual crawl of skillbox from fixtures: listing page has two articles,
+// only one of them is recorded, so the other one is moved to dead letters. Parsed article, dead letter
+// and progress of the run must reach storage, when parser is stopped.
+func TestCrawlReplay(t *testing.T) {
+	replayHab(t, "skillbox")
+
+	habs, ok := viper.Get("habs").([]any)
+	if !ok {
+		t.Fatalf("habs in configuration are %T", viper.Get("habs"))
+	}
+
+	idx := slices.IndexFunc(habs, func(hab any) bool {
+		def, ok := hab.(map[string]any)
+		return ok && def["hab-type"] == "skillbox"
+	})
+	if idx == -1 {
+		t.Fatal("hab skillbox is not in configuration")
+	}
+
+	viper.Set("habs", habs[idx:idx+1])
+	viper.Set("parser.politeness.delay", "0s")
+	viper.Set("parser.politeness.random-delay", "0s")
+	viper.Set("parser.retry.max-attempts", 1)
+
+	storage := newFakeStorage()
+	p, err := NewParser(storage, fetcher.NewReplayer(fixturesDir))
+	if err != nil {
+		t.Fatalf("failed to create parser, error: %v", err)
+	}
+
+	p.Parse()
+	id, err := p.CrawlHab("skillbox")
+	if err != nil {
+		t.Fatalf("failed to start crawl, error: %v", err)
+	}
+
+	var run models.CrawlRun
+	for deadline := time.Now().Add(10 * time.Second); run.FinishedAt == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("run is not finished, progress: %+v", run)
+		}
+
+		run, err = p.GetCrawlRun(id)
+		if err != nil {
+			t.Fatalf("failed to get run, error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = p.Stop(ctx)
+	if err != nil {
+		t.Fatalf("failed to stop parser, error: %v", err)
+	}
+
+	if run.Status != models.CrawlRunStatusDone || run.Urls != 2 || run.Queued != 2 || run.Parsed != 1 || run.Failed != 1 {
+		t.Errorf("run = %+v, want done with 2 urls, 2 queued, 1 parsed and 1 failed", run)
+	}
+
+	storage.mx.Lock()
+	defer storage.mx.Unlock()
+
+	if saved := storage.runs[id]; saved.Status != run.Status || saved.Parsed != run.Parsed || saved.Failed != run.Failed {
+		t.Errorf("saved run = %+v, want %+v", saved, run)
+	}
+
+	article, ok := storage.articles["https://skillbox.ru/media/code/kak-rabotat-s-gorutinami"]
+	if !ok || len(storage.articles) != 1 {
+		t.Fatalf("saved articles = %v, want only kak-rabotat-s-gorutinami", storage.articles)
+	}
+
+	if article.Title != "Как работать с горутинами" || article.Username != "Иван Петров" {
+		t.Errorf("title = %q, username = %q", article.Title, article.Username)
+	}
+
+	if _, ok = storage.deadLetters["https://skillbox.ru/media/design/chto-takoe-ux"]; !ok || len(storage.deadLetters) != 1 {
+		t.Errorf("dead letters = %v, want only chto-takoe-ux", storage.deadLetters)
+	}
+}
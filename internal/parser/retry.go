@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"errors"
+	"github.com/gocolly/colly/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"testTask/internal/database"
+	"testTask/internal/models"
+	"time"
+)
+
+// retryArticle sends article to parse again after exponential backoff. After parser.retry.max-attempts
+// failed attempts, or if page is disallowed by robots.txt, article is saved to dead letters.
+// If parser is stopped before the next attempt, run of the article is interrupted.
+func (p *Parser) retryArticle(val articleInfo, article *models.ArticleData, err error) {
+	val.attempt++
+
+	if val.attempt < viper.GetInt("parser.retry.max-attempts") && !errors.Is(err, colly.ErrRobotsTxtBlocked) {
+		delay := retryDelay(val.attempt)
+		logrus.Warnf("failed to parse article, retry in %s, URL: %s, attempt: %d, error: %v", delay, val.url, val.attempt, err)
+
+		time.AfterFunc(delay, func() {
+			select {
+			case p.c <- val:
+			case <-p.ctx.Done():
+				if val.run != nil {
+					val.run.interrupt()
+				}
+			}
+		})
+
+		return
+	}
+
+	logrus.Errorf("failed to parse article, move it to dead letters, URL: %s, attempts: %d, error: %v", val.url, val.attempt, err)
+	p.deadLetters.append(models.DeadLetter{
+		Url:      val.url,
+		HabType:  val.habType,
+		Reason:   err.Error(),
+		Attempts: val.attempt,
+		FailedAt: time.Now(),
+	})
+
+	if h, ok := p.getHab(val.habType); ok {
+		h.health.recordArticle(article)
+	}
+
+	if val.run != nil {
+		val.run.articleFailed()
+	}
+
+	if val.parsed != nil {
+		val.parsed <- article
+	}
+}
+
+// retryDelay returns delay before the next attempt: parser.retry.initial-delay doubled after every failed attempt,
+// but not more than parser.retry.max-delay.
+func retryDelay(attempt int) time.Duration {
+	delay := viper.GetDuration("parser.retry.initial-delay")
+	maxDelay := viper.GetDuration("parser.retry.max-delay")
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+// deadLettersBuf keeps dead letters until they are saved to storage by flushRoutine.
+type deadLettersBuf struct {
+	mx  sync.Mutex
+	buf []models.DeadLetter
+}
+
+func (d *deadLettersBuf) append(letter models.DeadLetter) {
+	d.mx.Lock()
+	d.buf = append(d.buf, letter)
+	d.mx.Unlock()
+}
+
+// swap returns buffered dead letters and replaces them with empty buffer.
+func (d *deadLettersBuf) swap() []models.DeadLetter {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	buf := d.buf
+	d.buf = nil
+	return buf
+}
+
+// putDeadLetters saves buffered dead letters to storage. Dead letters, which failed to be saved, are saved again next time.
+func (p *Parser) putDeadLetters() error {
+	errs := make([]error, 0)
+	for _, letter := range p.deadLetters.swap() {
+		err := p.storage.PutDeadLetter(letter)
+		if err != nil {
+			logrus.Errorf("failed to put dead letter, URL: %s, error: %v", letter.Url, err)
+			errs = append(errs, err)
+			p.deadLetters.append(letter)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// GetDeadLetters returns articles, which failed to be parsed, of the hab, or of all habs, if habType is empty.
+func (p *Parser) GetDeadLetters(habType string) ([]models.DeadLetter, error) {
+	_ = p.putDeadLetters()
+	return p.storage.GetDeadLetters(habType)
+}
+
+// RetryDeadLetter removes article from dead letters and sends it to parse again.
+// If article is not in dead letters, its hab does not exist or can parse articles only from the feed,
+// RetryDeadLetter returns an error.
+func (p *Parser) RetryDeadLetter(url string) error {
+	_ = p.putDeadLetters()
+	letter, err := p.storage.GetDeadLetter(normalizeUrl(url))
+	if err != nil {
+		if errors.Is(err, database.ErrRowNotExist) {
+			return ErrDeadLetterIsNotExist
+		}
+
+		return err
+	}
+
+	h, ok := p.getHab(letter.HabType)
+	if !ok {
+		return ErrHabIsNotExist
+	}
+
+	if h.parseFunctions.itemRequired {
+		return ErrFeedItemIsRequired
+	}
+
+	err = p.storage.DeleteDeadLetter(letter.Url)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case p.c <- articleInfo{url: letter.Url, habType: letter.HabType}:
+		case <-p.ctx.Done():
+		}
+	}()
+
+	return nil
+}
+
+// DiscardDeadLetter removes article from dead letters without parsing it again.
+func (p *Parser) DiscardDeadLetter(url string) error {
+	_ = p.putDeadLetters()
+	err := p.storage.DeleteDeadLetter(normalizeUrl(url))
+	if errors.Is(err, database.ErrRowNotExist) {
+		return ErrDeadLetterIsNotExist
+	}
+
+	return err
+}
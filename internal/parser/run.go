@@ -0,0 +1,470 @@
+package parser
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sort"
+	"sync"
+	"testTask/internal/database"
+	"testTask/internal/models"
+	"time"
+)
+
+// crawlRun is a progress of one main page parse of the hab and of parsing of the articles found during it.
+// Run is done, when main page is parsed and all queued articles are parsed or moved to dead letters.
+// Changes of the run are kept in memory and are saved to storage by runsSaver.
+// Lastmods of the sitemaps walked during the run are saved only when the run is done and its articles are saved,
+// so that sitemaps of the interrupted run are walked again.
+type crawlRun struct {
+	id      string
+	habType string
+	trigger string
+	saver   *runsSaver
+
+	mx         sync.Mutex
+	status     string
+	startedAt  time.Time
+	finishedAt time.Time
+	urls       int
+	queued     int
+	parsed     int
+	failed     int
+	err        string
+	sitemaps   []sitemapEntry
+}
+
+func newCrawlRun(habType string, trigger string, saver *runsSaver) *crawlRun {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	r := &crawlRun{
+		id:        hex.EncodeToString(id),
+		habType:   habType,
+		trigger:   trigger,
+		saver:     saver,
+		status:    models.CrawlRunStatusRunning,
+		startedAt: time.Now(),
+	}
+
+	r.markChanged()
+
+	return r
+}
+
+// finishMainPage saves amount of article urls found on the main page and the main page error.
+// Interrupted run is kept as is.
+func (r *crawlRun) finishMainPage(urls int, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if !r.finishedAt.IsZero() {
+		return
+	}
+
+	r.urls = urls
+	switch {
+	case errors.Is(err, ErrPageIsNotModified):
+		r.status = models.CrawlRunStatusNotModified
+	case err != nil:
+		r.status = models.CrawlRunStatusFailed
+		r.err = err.Error()
+	default:
+		r.status = models.CrawlRunStatusParsing
+	}
+
+	r.check()
+	r.markChanged()
+}
+
+func (r *crawlRun) articleQueued() {
+	r.mx.Lock()
+	r.queued++
+	r.mx.Unlock()
+}
+
+func (r *crawlRun) articleParsed() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.parsed++
+	r.check()
+	r.markChanged()
+}
+
+func (r *crawlRun) articleFailed() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.failed++
+	r.check()
+	r.markChanged()
+}
+
+// interrupt finishes the run, which is not finished yet, as interrupted.
+func (r *crawlRun) interrupt() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if !r.finishedAt.IsZero() {
+		return
+	}
+
+	r.status = models.CrawlRunStatusInterrupted
+	r.finishedAt = time.Now()
+	logrus.Warnf("run %s of %s is interrupted, urls: %d, queued: %d, parsed: %d, failed: %d",
+		r.id, r.habType, r.urls, r.queued, r.parsed, r.failed)
+	r.markChanged()
+}
+
+// sitemapWalked remembers sitemap, whose articles were all queued during the run.
+func (r *crawlRun) sitemapWalked(sitemap sitemapEntry) {
+	r.mx.Lock()
+	r.sitemaps = append(r.sitemaps, sitemap)
+	r.mx.Unlock()
+}
+
+// check finishes the run, if main page is parsed and there are no pending articles. Must be called with r.mx held.
+func (r *crawlRun) check() {
+	if r.status == models.CrawlRunStatusRunning || r.parsed+r.failed < r.queued || !r.finishedAt.IsZero() {
+		return
+	}
+
+	if r.status == models.CrawlRunStatusParsing {
+		r.status = models.CrawlRunStatusDone
+		if r.saver != nil {
+			r.saver.addSitemaps(r.habType, r.sitemaps)
+		}
+		r.sitemaps = nil
+	}
+
+	r.finishedAt = time.Now()
+	logrus.Infof("run %s of %s is %s, urls: %d, queued: %d, parsed: %d, failed: %d",
+		r.id, r.habType, r.status, r.urls, r.queued, r.parsed, r.failed)
+}
+
+// markChanged marks the run to be saved by runsSaver.
+func (r *crawlRun) markChanged() {
+	if r.saver != nil {
+		r.saver.add(r)
+	}
+}
+
+func (r *crawlRun) report() models.CrawlRun {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return r.snapshot()
+}
+
+// snapshot returns progress of the run. Must be called with r.mx held.
+func (r *crawlRun) snapshot() models.CrawlRun {
+	run := models.CrawlRun{
+		ID:        r.id,
+		HabType:   r.habType,
+		Trigger:   r.trigger,
+		Status:    r.status,
+		StartedAt: r.startedAt,
+		Urls:      r.urls,
+		Queued:    r.queued,
+		Parsed:    r.parsed,
+		Failed:    r.failed,
+		Error:     r.err,
+	}
+
+	if !r.finishedAt.IsZero() {
+		finishedAt := r.finishedAt
+		run.FinishedAt = &finishedAt
+	}
+
+	return run
+}
+
+// runsSaver keeps runs, which were changed since the previous save. Processing routines only mark runs
+// as changed, runs are saved by flushRoutine of the parser, so that routines are not blocked by storage.
+// runsSaver also keeps sitemaps of the done runs until articles of the runs are saved, see putArticleInTable.
+type runsSaver struct {
+	storage Storage
+
+	mx       sync.Mutex
+	changed  map[*crawlRun]struct{}
+	sitemaps []walkedSitemap
+
+	// saveMx serializes saves, so that older progress of the run does not overwrite newer one.
+	saveMx sync.Mutex
+}
+
+func newRunsSaver(storage Storage) *runsSaver {
+	return &runsSaver{
+		storage: storage,
+		changed: make(map[*crawlRun]struct{}),
+	}
+}
+
+func (s *runsSaver) add(r *crawlRun) {
+	s.mx.Lock()
+	s.changed[r] = struct{}{}
+	s.mx.Unlock()
+}
+
+// walkedSitemap is a sitemap of the hab, whose articles were all parsed during the run.
+type walkedSitemap struct {
+	habType string
+	sitemap sitemapEntry
+}
+
+func (s *runsSaver) addSitemaps(habType string, sitemaps []sitemapEntry) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, sitemap := range sitemaps {
+		s.sitemaps = append(s.sitemaps, walkedSitemap{habType: habType, sitemap: sitemap})
+	}
+}
+
+// takeSitemaps returns sitemaps of the done runs and forgets them.
+func (s *runsSaver) takeSitemaps() []walkedSitemap {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	sitemaps := s.sitemaps
+	s.sitemaps = nil
+	return sitemaps
+}
+
+// saveSitemapLastmods saves lastmods of the sitemaps, so that they are not walked again until they are modified.
+func (s *runsSaver) saveSitemapLastmods(sitemaps []walkedSitemap) {
+	for _, walked := range sitemaps {
+		err := s.storage.PutSitemapLastmod(walked.habType, walked.sitemap.loc, walked.sitemap.lastmod)
+		if err != nil {
+			logrus.Errorf("failed to save sitemap lastmod of %s, error: %v", walked.habType, err)
+		}
+	}
+}
+
+// save saves changed runs to storage. Runs, which failed to be saved, are saved again next time.
+func (s *runsSaver) save() error {
+	s.saveMx.Lock()
+	defer s.saveMx.Unlock()
+
+	s.mx.Lock()
+	changed := s.changed
+	s.changed = make(map[*crawlRun]struct{})
+	s.mx.Unlock()
+
+	errs := make([]error, 0)
+	for r := range changed {
+		err := s.storage.PutCrawlRun(r.report())
+		if err != nil {
+			logrus.Errorf("failed to save run %s of %s, error: %v", r.id, r.habType, err)
+			errs = append(errs, err)
+			s.add(r)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// startRun marks hab as running and returns a new run. It returns an error, if hab is already running
+// or is stopping, so that Stop does not miss the run, while waiting for crawls of the hab.
+// Recent runs are kept in the hab, their amount is limited by parser.runs-history.
+func (h *hab) startRun(trigger string) (*crawlRun, error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.ctx.Err() != nil {
+		return nil, ErrHabIsStopping
+	}
+
+	if h.running {
+		return nil, ErrRunIsAlreadyGoing
+	}
+
+	h.running = true
+	h.crawls.Add(1)
+	run := newCrawlRun(h.habType, trigger, h.runsSaver)
+	h.runs = append(h.runs, run)
+	if limit := max(viper.GetInt("parser.runs-history"), 1); len(h.runs) > limit {
+		h.runs = h.runs[len(h.runs)-limit:]
+	}
+
+	return run, nil
+}
+
+func (h *hab) finishRun() {
+	h.mx.Lock()
+	h.running = false
+	h.crawls.Done()
+	h.mx.Unlock()
+}
+
+// interruptRuns interrupts runs of the hab, which are not finished.
+func (h *hab) interruptRuns() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for _, run := range h.runs {
+		run.interrupt()
+	}
+}
+
+func (h *hab) findRun(id string) (*crawlRun, bool) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for _, run := range h.runs {
+		if run.id == id {
+			return run, true
+		}
+	}
+
+	return nil, false
+}
+
+// CrawlHab parses main page of the hab right away, without waiting for its schedule, which is not changed.
+// Main page is requested unconditionally, time of the last run is updated, when main page is parsed.
+// CrawlHab returns id of the run, which can be polled with GetCrawlRun.
+// If hab is not exist, is stopping or its run is already going, CrawlHab returns an error.
+func (p *Parser) CrawlHab(habType string) (string, error) {
+	h, ok := p.getHab(habType)
+	if !ok {
+		return "", ErrHabIsNotExist
+	}
+
+	run, err := h.startRun(models.CrawlTriggerManual)
+	if err != nil {
+		return "", err
+	}
+
+	logrus.Infof("start manual run %s of %s", run.id, habType)
+	go func() {
+		h.crawl(run)
+
+		h.mx.Lock()
+		h.lastRun = time.Now()
+		h.mx.Unlock()
+
+		_ = h.saveState()
+	}()
+
+	return run.id, nil
+}
+
+// GetCrawlRun returns progress of the run with id. Runs, which are not kept in habs, are taken from storage.
+// If run is not exist, GetCrawlRun returns an error.
+func (p *Parser) GetCrawlRun(id string) (models.CrawlRun, error) {
+	p.mx.RLock()
+	habs := make([]*hab, 0, len(p.habs))
+	for _, h := range p.habs {
+		habs = append(habs, h)
+	}
+	p.mx.RUnlock()
+
+	for _, h := range habs {
+		if run, ok := h.findRun(id); ok {
+			return run.report(), nil
+		}
+	}
+
+	run, err := p.storage.GetCrawlRun(id)
+	if errors.Is(err, database.ErrRowNotExist) {
+		return models.CrawlRun{}, ErrRunIsNotExist
+	}
+
+	return run, err
+}
+
+// GetHabRuns returns limit latest runs of the hab, including deleted one. If hab is not exist, GetHabRuns returns an error.
+func (p *Parser) GetHabRuns(habType string, limit int) ([]models.CrawlRun, error) {
+	if limit <= 0 {
+		return nil, ErrLimitIsNotPositive
+	}
+
+	_ = p.runsSaver.save()
+	habsInfo, err := p.storage.GetHabsInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range habsInfo {
+		if info.HabType == habType {
+			return p.storage.GetCrawlRuns(habType, limit)
+		}
+	}
+
+	return nil, ErrHabIsNotExist
+}
+
+// GetHabs returns state of all habs, including deleted ones, with their latest run and totals of the runs
+// during the last day. State of the working habs is taken from them, state of the deleted habs is taken from storage.
+func (p *Parser) GetHabs() ([]models.HabSummary, error) {
+	_ = p.runsSaver.save()
+	_ = p.putDeadLetters()
+	habsInfo, err := p.storage.GetHabsInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := p.storage.GetCrawlRunStats(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters, err := p.storage.GetDeadLettersCount()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]models.HabSummary, 0, len(habsInfo))
+	for _, info := range habsInfo {
+		state := info.State
+		var crawling bool
+		if h, ok := p.getHab(info.HabType); ok {
+			state = h.state()
+			state.Schedule = h.currentSchedule()
+			h.mx.Lock()
+			crawling = h.running
+			h.mx.Unlock()
+		} else if state.Schedule == "" && info.Definition != nil {
+			if s, err := habSchedule(*info.Definition); err == nil {
+				state.Schedule = s.String()
+			}
+		}
+
+		summary := models.HabSummary{
+			HabType:     info.HabType,
+			Status:      state.Status,
+			Schedule:    state.Schedule,
+			Crawling:    crawling,
+			Last24h:     stats[info.HabType],
+			DeadLetters: deadLetters[info.HabType],
+		}
+
+		if !state.LastRun.IsZero() {
+			summary.LastRun = &state.LastRun
+		}
+
+		if !state.NextRun.IsZero() && state.Status == models.HabStatusRunning {
+			summary.NextRun = &state.NextRun
+		}
+
+		runs, err := p.storage.GetCrawlRuns(info.HabType, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(runs) != 0 {
+			summary.LastCrawl = &runs[0]
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].HabType < summaries[j].HabType
+	})
+
+	return summaries, nil
+}
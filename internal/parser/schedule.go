@@ -0,0 +1,423 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+	"testTask/internal/models"
+	"time"
+)
+
+// maxScheduleLookahead limits search of the next run, schedule without runs in this period is invalid.
+const maxScheduleLookahead = 5 * 366 * 24 * time.Hour
+
+var (
+	cronMonths   = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// schedule returns time of the next run of the hab after the previous one.
+// String returns schedule in the form accepted by parseSchedule.
+type schedule interface {
+	next(after time.Time) time.Time
+	String() string
+}
+
+// habSchedule returns schedule of the hab: interval, cron expression or time windows from definition,
+// or parser.default-interval if none of them is specified.
+func habSchedule(def models.HabDefinition) (schedule, error) {
+	var specified int
+	for _, ok := range []bool{def.Interval != "", def.Cron != "", len(def.Windows) != 0} {
+		if ok {
+			specified++
+		}
+	}
+
+	if specified > 1 {
+		return nil, ErrScheduleIsAmbiguous
+	}
+
+	switch {
+	case def.Interval != "":
+		return parseInterval(def.Interval)
+
+	case def.Cron != "":
+		return parseCron(def.Cron)
+
+	case len(def.Windows) != 0:
+		specs := make([]string, 0, len(def.Windows))
+		for _, window := range def.Windows {
+			specs = append(specs, strings.TrimSpace(fmt.Sprintf("%s %s-%s %s", window.Days, window.From, window.To, window.Interval)))
+		}
+
+		return parseWindows(strings.Join(specs, "; "))
+	}
+
+	return intervalSchedule(viper.GetDuration("parser.default-interval")), nil
+}
+
+// parseSchedule parses interval like 10m, cron expression like "*/15 9-18 * * mon-fri"
+// or list of time windows like "mon-fri 09:00-18:00 10m; 18:00-09:00 2h".
+// Cron expressions and time windows use parser.default-timezone.
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return nil, ErrScheduleIsEmpty
+	case strings.Contains(spec, ":"):
+		return parseWindows(spec)
+	case len(strings.Fields(spec)) == 1:
+		return parseInterval(spec)
+	}
+
+	return parseCron(spec)
+}
+
+func scheduleLocation() (*time.Location, error) {
+	return time.LoadLocation(viper.GetString("parser.default-timezone"))
+}
+
+// checkSchedule checks that schedule has runs in the future.
+func checkSchedule(s schedule) (schedule, error) {
+	if s.next(time.Now()).IsZero() {
+		return nil, ErrScheduleHasNoRuns
+	}
+
+	return s, nil
+}
+
+// intervalSchedule runs the hab with fixed interval.
+type intervalSchedule time.Duration
+
+func parseInterval(spec string) (schedule, error) {
+	interval, err := time.ParseDuration(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		return nil, ErrIntervalIsNotPositive
+	}
+
+	return intervalSchedule(interval), nil
+}
+
+func (s intervalSchedule) next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+func (s intervalSchedule) String() string {
+	return time.Duration(s).String()
+}
+
+// cronSchedule runs the hab at times matching cron expression with minute, hour, day of month,
+// month and day of week fields. As in cron, if both day fields are restricted, day matching any of them is used.
+type cronSchedule struct {
+	spec     string
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	anyDay   bool
+	location *time.Location
+}
+
+func parseCron(spec string) (schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrCronIsInvalid
+	}
+
+	location, err := scheduleLocation()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &cronSchedule{
+		spec:     strings.Join(fields, " "),
+		anyDay:   fields[2] == "*" || fields[4] == "*",
+		location: location,
+	}
+
+	for _, field := range []struct {
+		bits     *uint64
+		spec     string
+		min, max int
+		names    []string
+	}{
+		{&s.minutes, fields[0], 0, 59, nil},
+		{&s.hours, fields[1], 0, 23, nil},
+		{&s.days, fields[2], 1, 31, nil},
+		{&s.months, fields[3], 1, 12, cronMonths},
+		{&s.weekdays, fields[4], 0, 7, cronWeekdays},
+	} {
+		*field.bits, err = parseCronField(field.spec, field.min, field.max, field.names)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// both 0 and 7 are sunday
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+
+	return checkSchedule(s)
+}
+
+// parseCronField parses comma separated list of values, ranges and *, each of them can have /step.
+// Values can be numbers or names, which are matched by their index.
+func parseCronField(spec string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepSpec)
+			if err != nil || step <= 0 {
+				return 0, ErrCronIsInvalid
+			}
+		}
+
+		from, to := min, max
+		if rangeSpec != "*" {
+			fromSpec, toSpec, isRange := strings.Cut(rangeSpec, "-")
+
+			var err error
+			from, err = parseCronValue(fromSpec, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+
+			to = from
+			if isRange {
+				to, err = parseCronValue(toSpec, min, max, names)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				to = max
+			}
+
+			if from > to {
+				return 0, ErrCronIsInvalid
+			}
+		}
+
+		for value := from; value <= to; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(spec string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(spec, name) {
+			return i, nil
+		}
+	}
+
+	value, err := strconv.Atoi(spec)
+	if err != nil || value < min || value > max {
+		return 0, ErrCronIsInvalid
+	}
+
+	return value, nil
+}
+
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxScheduleLookahead)
+
+	for t.Before(limit) {
+		year, month, day := t.Date()
+
+		switch {
+		case s.months&(1<<int(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, s.location)
+		case s.hours&(1<<t.Hour()) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, s.location)
+		case s.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+
+	if s.anyDay {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+func (s *cronSchedule) String() string {
+	return s.spec
+}
+
+// windowsSchedule runs the hab with interval of the time window, which is active at the moment.
+// Outside of the windows hab is not parsed.
+type windowsSchedule struct {
+	windows  []timeWindow
+	location *time.Location
+}
+
+// timeWindow is active on days from the from time of the day during length,
+// so window can end on the next day.
+type timeWindow struct {
+	spec     string
+	days     uint64
+	from     time.Duration
+	length   time.Duration
+	interval time.Duration
+}
+
+// parseWindows parses list of time windows separated by semicolons. Every window is an optional list of week days
+// in cron format, like mon-fri or sat,sun, time range like 09:00-18:00 and interval.
+func parseWindows(spec string) (schedule, error) {
+	location, err := scheduleLocation()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &windowsSchedule{location: location}
+	for _, windowSpec := range strings.Split(spec, ";") {
+		window, err := parseTimeWindow(windowSpec)
+		if err != nil {
+			return nil, err
+		}
+
+		s.windows = append(s.windows, window)
+	}
+
+	return checkSchedule(s)
+}
+
+func parseTimeWindow(spec string) (timeWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 2 {
+		fields = append([]string{"*"}, fields...)
+	}
+
+	if len(fields) != 3 {
+		return timeWindow{}, ErrTimeWindowIsInvalid
+	}
+
+	days, err := parseCronField(fields[0], 0, 7, cronWeekdays)
+	if err != nil {
+		return timeWindow{}, ErrTimeWindowIsInvalid
+	}
+
+	if days&(1<<7) != 0 {
+		days |= 1
+	}
+
+	fromSpec, toSpec, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return timeWindow{}, ErrTimeWindowIsInvalid
+	}
+
+	from, err := parseTimeOfDay(fromSpec)
+	if err != nil {
+		return timeWindow{}, err
+	}
+
+	to, err := parseTimeOfDay(toSpec)
+	if err != nil {
+		return timeWindow{}, err
+	}
+
+	interval, err := time.ParseDuration(fields[2])
+	if err != nil {
+		return timeWindow{}, err
+	}
+
+	if interval <= 0 {
+		return timeWindow{}, ErrIntervalIsNotPositive
+	}
+
+	length := to - from
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+
+	return timeWindow{
+		spec:     strings.Join(fields, " "),
+		days:     days,
+		from:     from,
+		length:   length,
+		interval: interval,
+	}, nil
+}
+
+func parseTimeOfDay(spec string) (time.Duration, error) {
+	t, err := time.Parse("15:04", spec)
+	if err != nil {
+		return 0, ErrTimeWindowIsInvalid
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (s *windowsSchedule) next(after time.Time) time.Time {
+	var next time.Time
+	for _, window := range s.windows {
+		candidate := window.next(after.In(s.location))
+		if !candidate.IsZero() && (next.IsZero() || candidate.Before(next)) {
+			next = candidate
+		}
+	}
+
+	return next
+}
+
+// next returns after with interval of the window, if both of them are in the window,
+// otherwise it returns the next start of the window.
+func (w timeWindow) next(after time.Time) time.Time {
+	year, month, day := after.Date()
+
+	// window, which started on the previous day, can be active now
+	for offset := -1; offset <= 7; offset++ {
+		date := time.Date(year, month, day+offset, 0, 0, 0, 0, after.Location())
+		if w.days&(1<<int(date.Weekday())) == 0 {
+			continue
+		}
+
+		start := time.Date(year, month, day+offset, int(w.from/time.Hour), int(w.from%time.Hour/time.Minute), 0, 0, after.Location())
+		end := start.Add(w.length)
+
+		if start.After(after) {
+			return start
+		}
+
+		if candidate := after.Add(w.interval); after.Before(end) && candidate.Before(end) {
+			return candidate
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *windowsSchedule) String() string {
+	specs := make([]string, 0, len(s.windows))
+	for _, window := range s.windows {
+		specs = append(specs, window.spec)
+	}
+
+	return strings.Join(specs, "; ")
+}
@@ -0,0 +1,336 @@
+package parser
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"testTask/internal/models"
+	"testing"
+	"time"
+)
+
+// setScheduleTimezone sets timezone, in which schedules parsed by the test are evaluated.
+func setScheduleTimezone(t *testing.T, timezone string) {
+	t.Helper()
+
+	_, err := time.LoadLocation(timezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("parser.default-timezone", timezone)
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		timezone string
+		spec     string
+		after    string
+		want     []string
+	}{
+		{
+			name:  "interval",
+			spec:  "90m",
+			after: "2024-03-13 10:07",
+			want:  []string{"2024-03-13 11:37", "2024-03-13 13:07"},
+		},
+		{
+			name:  "cron step",
+			spec:  "*/15 * * * *",
+			after: "2024-03-13 10:07",
+			want:  []string{"2024-03-13 10:15", "2024-03-13 10:30", "2024-03-13 10:45", "2024-03-13 11:00"},
+		},
+		{
+			name:  "cron skips exact time",
+			spec:  "0 9 * * *",
+			after: "2024-03-13 09:00",
+			want:  []string{"2024-03-14 09:00"},
+		},
+		{
+			name:  "cron hour range and list",
+			spec:  "0,30 9-10 * * *",
+			after: "2024-03-13 08:59",
+			want:  []string{"2024-03-13 09:00", "2024-03-13 09:30", "2024-03-13 10:00", "2024-03-13 10:30", "2024-03-14 09:00"},
+		},
+		{
+			name:  "cron weekdays over weekend",
+			spec:  "0 9 * * mon-fri",
+			after: "2024-03-15 10:00",
+			want:  []string{"2024-03-18 09:00", "2024-03-19 09:00"},
+		},
+		{
+			name:  "cron sunday is 7",
+			spec:  "0 0 * * 7",
+			after: "2024-03-18 00:00",
+			want:  []string{"2024-03-24 00:00", "2024-03-31 00:00"},
+		},
+		{
+			name:  "cron month names",
+			spec:  "0 12 1 jan,JUL *",
+			after: "2024-03-13 00:00",
+			want:  []string{"2024-07-01 12:00", "2025-01-01 12:00"},
+		},
+		{
+			name:  "cron day of month and any weekday",
+			spec:  "0 0 13 * *",
+			after: "2024-09-01 00:00",
+			want:  []string{"2024-09-13 00:00", "2024-10-13 00:00"},
+		},
+		{
+			name:  "cron day of month or day of week",
+			spec:  "0 0 13 * fri",
+			after: "2024-09-01 00:00",
+			want:  []string{"2024-09-06 00:00", "2024-09-13 00:00", "2024-09-20 00:00", "2024-09-27 00:00", "2024-10-04 00:00", "2024-10-11 00:00", "2024-10-13 00:00"},
+		},
+		{
+			name:  "cron leap day",
+			spec:  "0 12 29 feb *",
+			after: "2024-03-01 00:00",
+			want:  []string{"2028-02-29 12:00"},
+		},
+		{
+			name:     "cron in timezone",
+			timezone: "Europe/Moscow",
+			spec:     "0 9 * * *",
+			after:    "2024-03-13 07:00",
+			want:     []string{"2024-03-14 06:00"},
+		},
+		{
+			name:     "cron skips time missing on dst start",
+			timezone: "Europe/Berlin",
+			spec:     "30 2 * * *",
+			after:    "2024-03-30 12:00",
+			want:     []string{"2024-04-01 00:30"},
+		},
+		{
+			name:     "cron runs repeated hour on dst end",
+			timezone: "Europe/Berlin",
+			spec:     "0 * * * *",
+			after:    "2024-10-26 23:30",
+			want:     []string{"2024-10-27 00:00", "2024-10-27 01:00", "2024-10-27 02:00"},
+		},
+		{
+			name:  "window interval",
+			spec:  "mon-fri 09:00-18:00 10m; 18:00-09:00 2h",
+			after: "2024-03-13 10:00",
+			want:  []string{"2024-03-13 10:10"},
+		},
+		{
+			name:  "window switches at its end",
+			spec:  "mon-fri 09:00-18:00 10m; 18:00-09:00 2h",
+			after: "2024-03-13 17:55",
+			want:  []string{"2024-03-13 18:00", "2024-03-13 20:00", "2024-03-13 22:00", "2024-03-14 00:00"},
+		},
+		{
+			name:  "window across midnight",
+			spec:  "22:00-02:00 1h",
+			after: "2024-03-13 23:30",
+			want:  []string{"2024-03-14 00:30", "2024-03-14 01:30", "2024-03-14 22:00"},
+		},
+		{
+			name:  "window over weekend",
+			spec:  "mon-fri 09:00-18:00 30m",
+			after: "2024-03-15 17:45",
+			want:  []string{"2024-03-18 09:00", "2024-03-18 09:30"},
+		},
+		{
+			name:  "window started on previous day",
+			spec:  "fri 22:00-02:00 1h",
+			after: "2024-03-16 00:30",
+			want:  []string{"2024-03-16 01:30", "2024-03-22 22:00"},
+		},
+		{
+			name:  "window for the whole day",
+			spec:  "sat,sun 00:00-00:00 6h",
+			after: "2024-03-15 12:00",
+			want:  []string{"2024-03-16 00:00", "2024-03-16 06:00", "2024-03-16 12:00", "2024-03-16 18:00", "2024-03-17 00:00"},
+		},
+		{
+			name:     "window in timezone",
+			timezone: "Europe/Moscow",
+			spec:     "09:00-18:00 1h",
+			after:    "2024-03-13 00:00",
+			want:     []string{"2024-03-13 06:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timezone := tt.timezone
+			if timezone == "" {
+				timezone = "UTC"
+			}
+			setScheduleTimezone(t, timezone)
+
+			s, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("failed to parse %q, error: %v", tt.spec, err)
+			}
+
+			after := mustParseUTC(t, tt.after)
+			for _, want := range tt.want {
+				next := s.next(after)
+				if !next.Equal(mustParseUTC(t, want)) {
+					t.Fatalf("next after %s = %s, want %s UTC", after, next.UTC(), want)
+				}
+
+				after = next
+			}
+		})
+	}
+}
+
+func TestScheduleStringRoundTrip(t *testing.T) {
+	setScheduleTimezone(t, "Europe/Moscow")
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{spec: "10m", want: "10m0s"},
+		{spec: "1h30m", want: "1h30m0s"},
+		{spec: "*/15  9-18 * * mon-fri", want: "*/15 9-18 * * mon-fri"},
+		{spec: "0 0 13 * fri", want: "0 0 13 * fri"},
+		{spec: "mon-fri 09:00-18:00 10m;18:00-09:00 2h", want: "mon-fri 09:00-18:00 10m; * 18:00-09:00 2h"},
+		{spec: "sat,sun  22:00-02:00 1h", want: "sat,sun 22:00-02:00 1h"},
+	}
+
+	samples := []time.Time{
+		time.Date(2024, time.March, 13, 10, 7, 0, 0, time.UTC),
+		time.Date(2024, time.March, 15, 17, 55, 0, 0, time.UTC),
+		time.Date(2024, time.March, 16, 23, 30, 0, 0, time.UTC),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("failed to parse %q, error: %v", tt.spec, err)
+			}
+
+			if s.String() != tt.want {
+				t.Errorf("String() = %q, want %q", s.String(), tt.want)
+			}
+
+			restored, err := parseSchedule(s.String())
+			if err != nil {
+				t.Fatalf("failed to parse %q, error: %v", s.String(), err)
+			}
+
+			if restored.String() != s.String() {
+				t.Errorf("restored String() = %q, want %q", restored.String(), s.String())
+			}
+
+			for _, sample := range samples {
+				if !restored.next(sample).Equal(s.next(sample)) {
+					t.Errorf("restored next after %s = %s, want %s", sample, restored.next(sample), s.next(sample))
+				}
+			}
+		})
+	}
+}
+
+func TestHabSchedule(t *testing.T) {
+	setScheduleTimezone(t, "UTC")
+	viper.Set("parser.default-interval", "10m")
+
+	tests := []struct {
+		name string
+		def  models.HabDefinition
+		want string
+		err  error
+	}{
+		{name: "default interval", def: models.HabDefinition{}, want: "10m0s"},
+		{name: "interval", def: models.HabDefinition{Interval: "1h"}, want: "1h0m0s"},
+		{name: "cron", def: models.HabDefinition{Cron: "0 9 * * *"}, want: "0 9 * * *"},
+		{
+			name: "windows",
+			def: models.HabDefinition{Windows: []models.TimeWindow{
+				{Days: "mon-fri", From: "09:00", To: "18:00", Interval: "10m"},
+				{From: "18:00", To: "09:00", Interval: "2h"},
+			}},
+			want: "mon-fri 09:00-18:00 10m; * 18:00-09:00 2h",
+		},
+		{name: "ambiguous", def: models.HabDefinition{Interval: "1h", Cron: "0 9 * * *"}, err: ErrScheduleIsAmbiguous},
+		{name: "invalid window", def: models.HabDefinition{Windows: []models.TimeWindow{{From: "9", To: "18:00", Interval: "1h"}}}, err: ErrTimeWindowIsInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := habSchedule(tt.def)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if s.String() != tt.want {
+				t.Errorf("String() = %q, want %q", s.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	setScheduleTimezone(t, "UTC")
+
+	tests := []struct {
+		spec string
+		err  error
+	}{
+		{spec: "", err: ErrScheduleIsEmpty},
+		{spec: "  ", err: ErrScheduleIsEmpty},
+		{spec: "0s", err: ErrIntervalIsNotPositive},
+		{spec: "-5m", err: ErrIntervalIsNotPositive},
+		{spec: "soon"},
+		{spec: "* * * *", err: ErrCronIsInvalid},
+		{spec: "* * * * * *", err: ErrCronIsInvalid},
+		{spec: "60 * * * *", err: ErrCronIsInvalid},
+		{spec: "* 24 * * *", err: ErrCronIsInvalid},
+		{spec: "* * 0 * *", err: ErrCronIsInvalid},
+		{spec: "* * * 13 *", err: ErrCronIsInvalid},
+		{spec: "* * * * 8", err: ErrCronIsInvalid},
+		{spec: "5-1 * * * *", err: ErrCronIsInvalid},
+		{spec: "*/0 * * * *", err: ErrCronIsInvalid},
+		{spec: "* * * foo *", err: ErrCronIsInvalid},
+		{spec: "0 0 31 feb *", err: ErrScheduleHasNoRuns},
+		{spec: "mon-fri 9-18 10m", err: ErrCronIsInvalid},
+		{spec: "09:00-18:00", err: ErrTimeWindowIsInvalid},
+		{spec: "09:00 18:00 10m", err: ErrTimeWindowIsInvalid},
+		{spec: "someday 09:00-18:00 10m", err: ErrTimeWindowIsInvalid},
+		{spec: "25:00-18:00 10m", err: ErrTimeWindowIsInvalid},
+		{spec: "09:00-18:00 0s", err: ErrIntervalIsNotPositive},
+		{spec: "09:00-18:00 often"},
+		{spec: "mon 09:00-18:00 10m; ", err: ErrTimeWindowIsInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if err == nil {
+				t.Fatalf("parsed %q as %q, want error", tt.spec, s)
+			}
+
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func mustParseUTC(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	date, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return date
+}
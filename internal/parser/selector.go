@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/gocolly/colly/v2"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+	"regexp"
+	"strings"
+	"testTask/internal/models"
+)
+
+// fieldElement is an element matched by CSS selector or XPath expression of the field.
+type fieldElement struct {
+	request *colly.Request
+	text    string
+	attr    func(key string) string
+	html    func() (string, error)
+}
+
+// validateFieldSelector checks that field has only one of CSS selector and XPath expression,
+// and that its XPath expression and regex are valid.
+func validateFieldSelector(field models.FieldSelector) error {
+	if field.Selector != "" && field.XPath != "" {
+		return ErrSelectorAndXPathAreSpecified
+	}
+
+	if field.XPath != "" {
+		if _, err := xpath.Compile(field.XPath); err != nil {
+			return err
+		}
+	}
+
+	_, err := regexp.Compile(field.Regex)
+	return err
+}
+
+// onElements calls handle with every element matched by CSS selector or XPath expression of the field.
+func onElements(collector *colly.Collector, field models.FieldSelector, handle func(element fieldElement)) {
+	switch {
+	case field.Selector != "":
+		collector.OnHTML(field.Selector, func(htmlElement *colly.HTMLElement) {
+			handle(fieldElement{
+				request: htmlElement.Request,
+				text:    htmlElement.Text,
+				attr:    htmlElement.Attr,
+				html:    htmlElement.DOM.Html,
+			})
+		})
+
+	case field.XPath != "":
+		collector.OnXML(field.XPath, func(xmlElement *colly.XMLElement) {
+			element := fieldElement{
+				request: xmlElement.Request,
+				text:    xmlElement.Text,
+				attr:    xmlElement.Attr,
+				html: func() (string, error) {
+					return xmlElement.Text, nil
+				},
+			}
+
+			if node, ok := xmlElement.DOM.(*html.Node); ok {
+				element.html = func() (string, error) {
+					return htmlquery.OutputHTML(node, false), nil
+				}
+			}
+
+			handle(element)
+		})
+	}
+}
+
+// onValues calls set with the value of every element matched by the field, elements with empty value are skipped.
+// Value is the text of the element or its attribute Attr. If Regex is specified, value is replaced
+// with the first capture group of the regex, or with the whole match, if regex has no groups.
+// After that spaces and characters from Trim are trimmed.
+func onValues(collector *colly.Collector, field models.FieldSelector, set func(element fieldElement, value string)) {
+	if field.IsEmpty() {
+		return
+	}
+
+	var regex *regexp.Regexp
+	if field.Regex != "" {
+		regex = regexp.MustCompile(field.Regex)
+	}
+
+	onElements(collector, field, func(element fieldElement) {
+		value := element.text
+		if field.Attr != "" {
+			value = element.attr(field.Attr)
+		}
+
+		if regex != nil {
+			match := regex.FindStringSubmatch(value)
+			switch {
+			case match == nil:
+				value = ""
+			case len(match) > 1:
+				value = match[1]
+			default:
+				value = match[0]
+			}
+		}
+
+		value = strings.TrimSpace(strings.Trim(strings.TrimSpace(value), field.Trim))
+		if value == "" {
+			return
+		}
+
+		set(element, value)
+	})
+}
+
+// onField calls set with the value of the first element matched by the field.
+func onField(collector *colly.Collector, field models.FieldSelector, set func(element fieldElement, value string)) {
+	var found bool
+	onValues(collector, field, func(element fieldElement, value string) {
+		if found {
+			return
+		}
+
+		found = true
+		set(element, value)
+	})
+}
+
+// onMetric sets metric to the number from the first element matched by the field.
+func onMetric(collector *colly.Collector, field models.FieldSelector, metric *int) {
+	onField(collector, field, func(element fieldElement, value string) {
+		var err error
+		*metric, err = parseMetric(value)
+		if err != nil {
+			logrus.Errorf("failed to parse metric, URL: %s, error: %v", element.request.URL, err)
+		}
+	})
+}
+
+// onBody calls set with sanitized html and plain text of the first element matched by the field.
+// Attr, Regex and Trim are not used for body.
+func onBody(collector *colly.Collector, field models.FieldSelector, set func(bodyHtml string, bodyText string)) {
+	if field.IsEmpty() {
+		return
+	}
+
+	var found bool
+	onElements(collector, field, func(element fieldElement) {
+		if found {
+			return
+		}
+
+		rawHtml, err := element.html()
+		if err != nil {
+			logrus.Errorf("failed to extract body, URL: %s, error: %v", element.request.URL, err)
+			return
+		}
+
+		bodyHtml, bodyText, err := sanitizeBody(rawHtml)
+		if err != nil {
+			logrus.Errorf("failed to extract body, URL: %s, error: %v", element.request.URL, err)
+			return
+		}
+
+		found = true
+		set(bodyHtml, bodyText)
+	})
+}
@@ -0,0 +1,255 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/antchfx/xmlquery"
+	"github.com/gocolly/colly/v2"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"testTask/internal/database"
+	"time"
+)
+
+const (
+	// maxSitemapDepth limits nesting of sitemap indexes.
+	maxSitemapDepth = 3
+	// maxSitemapSize is a maximum size of uncompressed sitemap according to sitemaps protocol.
+	maxSitemapSize = 50 << 20
+)
+
+var ErrUnknownSitemapFormat = errors.New("unknown sitemap format, urlset or sitemapindex is expected")
+
+var sitemapLastmodLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	time.DateOnly,
+}
+
+type sitemapEntry struct {
+	loc     string
+	lastmod time.Time
+}
+
+// parseSitemap walks sitemap of the hab, starting from its main page url, which is requested conditionally.
+// Nested sitemaps are walked only if their lastmod is newer than the saved one,
+// articles are sent to parse only if they are not stored yet or their lastmod is newer than the stored one.
+// Lastmod of the nested sitemap is saved only after all its articles are parsed during the run.
+// parseSitemap returns amount of entries found in the walked sitemaps.
+func (h *hab) parseSitemap(run *crawlRun) (int, error) {
+	return h.walkSitemap(run, h.parseFunctions.habMainPageUrl, 1)
+}
+
+func (h *hab) walkSitemap(run *crawlRun, sitemapUrl string, depth int) (int, error) {
+	logrus.Infof("start parse sitemap of %s, URL: %s", h.habType, sitemapUrl)
+
+	visit := visitPage
+	if depth == 1 {
+		visit = h.mainPageVisitor(run)
+	}
+
+	sitemaps, articles, err := fetchSitemap(h.parseFunctions.newCollector(), sitemapUrl, visit)
+	if err != nil {
+		return 0, err
+	}
+
+	found := len(sitemaps) + len(articles)
+
+	for _, sitemap := range sitemaps {
+		if depth >= maxSitemapDepth {
+			logrus.Warnf("sitemap of %s is nested too deep, URL: %s", h.habType, sitemap.loc)
+			break
+		}
+
+		if h.ctx.Err() != nil {
+			return found, h.ctx.Err()
+		}
+
+		if !h.sitemapIsModified(sitemap) {
+			continue
+		}
+
+		var nested int
+		nested, err = h.walkSitemap(run, sitemap.loc, depth+1)
+		found += nested
+		if err != nil {
+			logrus.Errorf("failed to parse sitemap of %s, URL: %s, error: %v", h.habType, sitemap.loc, err)
+			continue
+		}
+
+		run.sitemapWalked(sitemap)
+	}
+
+	err = h.sendSitemapArticlesToParse(run, articles)
+	return found, err
+}
+
+// sitemapIsModified returns true, if sitemap was not walked yet or it is modified after the last walk.
+// Sitemap without lastmod is always considered modified.
+func (h *hab) sitemapIsModified(sitemap sitemapEntry) bool {
+	if sitemap.lastmod.IsZero() {
+		return true
+	}
+
+	saved, err := h.storage.GetSitemapLastmod(sitemap.loc)
+	if err != nil {
+		if !errors.Is(err, database.ErrRowNotExist) {
+			logrus.Errorf("failed to get sitemap lastmod of %s, error: %v", h.habType, err)
+		}
+
+		return true
+	}
+
+	return sitemap.lastmod.After(saved)
+}
+
+// sendSitemapArticlesToParse filters sitemap entries with the hab pattern
+// and sends to parse new and modified articles during the run. New articles, which were already seen
+// or are in dead letters, are skipped, because they are being parsed or failed to be parsed.
+// It returns an error, if not all of the articles were sent.
+func (h *hab) sendSitemapArticlesToParse(run *crawlRun, entries []sitemapEntry) error {
+	pattern := h.parseFunctions.sitemapPattern
+
+	lastmods := make(map[string]time.Time, len(entries))
+	urls := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if pattern != nil && !pattern.MatchString(entry.loc) {
+			continue
+		}
+
+		url := normalizeUrl(entry.loc)
+		if _, ok := lastmods[url]; ok {
+			continue
+		}
+
+		lastmods[url] = entry.lastmod
+		urls = append(urls, url)
+	}
+
+	if len(urls) == 0 {
+		return nil
+	}
+
+	stored, err := h.storage.GetArticleLastmods(urls)
+	if err != nil {
+		logrus.Errorf("failed to get stored articles of %s, error: %v", h.habType, err)
+		return err
+	}
+
+	failed, err := h.storage.GetDeadLetterUrls(urls)
+	if err != nil {
+		logrus.Errorf("failed to get dead letters of %s, error: %v", h.habType, err)
+		return err
+	}
+
+	for _, url := range urls {
+		lastmod := lastmods[url]
+		storedLastmod, ok := stored[url]
+		if ok && !lastmod.After(storedLastmod) {
+			continue
+		}
+
+		if _, isFailed := failed[url]; !ok && (isFailed || h.seenArticles.contains(url)) {
+			continue
+		}
+
+		h.seenArticles.add(url)
+		select {
+		case h.c <- articleInfo{url: url, habType: h.habType, lastmod: lastmod, run: run}:
+			run.articleQueued()
+		case <-h.ctx.Done():
+			return h.ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// fetchSitemap downloads sitemap, which can be gzipped, by visit and returns its nested sitemaps and articles.
+func fetchSitemap(collector *colly.Collector, sitemapUrl string, visit pageVisitor) ([]sitemapEntry, []sitemapEntry, error) {
+	collector.MaxBodySize = maxSitemapSize
+
+	var body []byte
+	collector.OnResponse(func(response *colly.Response) {
+		body = response.Body
+	})
+
+	err := visit(collector, sitemapUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		body, err = io.ReadAll(io.LimitReader(reader, maxSitemapSize))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return decodeSitemap(sitemapUrl, body)
+}
+
+// decodeSitemap parses sitemap index or urlset. Relative locations are resolved against sitemap url.
+func decodeSitemap(sitemapUrl string, body []byte) ([]sitemapEntry, []sitemapEntry, error) {
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := doc.SelectElement("*")
+	if root == nil {
+		return nil, nil, ErrUnknownSitemapFormat
+	}
+
+	switch root.Data {
+	case "sitemapindex":
+		return sitemapEntries(sitemapUrl, xmlquery.Find(root, "sitemap")), nil, nil
+	case "urlset":
+		return nil, sitemapEntries(sitemapUrl, xmlquery.Find(root, "url")), nil
+	}
+
+	return nil, nil, ErrUnknownSitemapFormat
+}
+
+func sitemapEntries(sitemapUrl string, nodes []*xmlquery.Node) []sitemapEntry {
+	entries := make([]sitemapEntry, 0, len(nodes))
+	for _, node := range nodes {
+		loc := node.SelectElement("loc")
+		if loc == nil || strings.TrimSpace(loc.InnerText()) == "" {
+			continue
+		}
+
+		entry := sitemapEntry{loc: resolveReference(sitemapUrl, strings.TrimSpace(loc.InnerText()))}
+		if lastmod := node.SelectElement("lastmod"); lastmod != nil {
+			entry.lastmod = parseSitemapLastmod(lastmod.InnerText())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func parseSitemapLastmod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range sitemapLastmodLayouts {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date
+		}
+	}
+
+	logrus.Errorf("failed to parse sitemap lastmod: %s", value)
+	return time.Time{}
+}
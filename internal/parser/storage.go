@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"testTask/internal/database"
+	"testTask/internal/models"
+	"time"
+)
+
+var _ Storage = (*database.Database)(nil)
+
+// Storage keeps habs, articles and progress of the parser. It is implemented by database.Database.
+// Methods, which return one row by its key, return database.ErrRowNotExist, if there is no such row.
+type Storage interface {
+	PutHab(def models.HabDefinition) error
+	GetHabInfo(habType string) error
+	GetHabsInfo() ([]models.HabInfo, error)
+	UpdateHabDefinition(def models.HabDefinition) (bool, error)
+	PutHabState(habType string, state models.HabState) error
+	DeleteHab(habType string) ([]int, error)
+
+	PutArticle(article *models.ArticleData) (int, error)
+	PutArticles(articles []*models.ArticleData) ([]int, error)
+	GetStoredArticleUrls(urls []string) (map[string]struct{}, error)
+	GetLastArticleUrls(habType string, limit int) ([]string, error)
+	GetArticleLastmods(urls []string) (map[string]time.Time, error)
+	GetArticleDates(urls []string) (map[string]time.Time, error)
+	GetArticleUrlsToReparse(habType string) ([]string, error)
+	NormalizeArticleUrls(normalize func(string) string) (int, error)
+
+	GetArticlesForRevisit(since time.Time, firstInterval time.Duration, habTypes []string, limit int) ([]models.ArticleData, error)
+	PutArticleMetrics(articleId int, metrics models.ArticleMetrics) error
+	GetArticleMetrics(articleId int) ([]models.ArticleMetrics, error)
+
+	GetSitemapLastmod(sitemapUrl string) (time.Time, error)
+	PutSitemapLastmod(habType string, sitemapUrl string, lastmod time.Time) error
+
+	PutDeadLetter(letter models.DeadLetter) error
+	GetDeadLetter(url string) (models.DeadLetter, error)
+	GetDeadLetters(habType string) ([]models.DeadLetter, error)
+	GetDeadLetterUrls(urls []string) (map[string]struct{}, error)
+	GetDeadLettersCount() (map[string]int, error)
+	DeleteDeadLetter(url string) error
+
+	PutCrawlRun(run models.CrawlRun) error
+	GetCrawlRun(id string) (models.CrawlRun, error)
+	GetCrawlRuns(habType string, limit int) ([]models.CrawlRun, error)
+	GetCrawlRunStats(since time.Time) (map[string]models.HabRunStats, error)
+	InterruptCrawlRuns() (int64, error)
+
+	PutBackfill(state models.BackfillState) error
+	GetBackfills() ([]models.BackfillState, error)
+}
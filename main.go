@@ -2,17 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
 	"html/template"
 	"os"
 	"os/signal"
+	"syscall"
 	"testTask/internal/cast"
 	"testTask/internal/database"
 	"testTask/internal/endpoint"
+	"testTask/internal/fetcher"
 	"testTask/internal/parser"
 	"testTask/internal/user"
+	"time"
 )
 
 var (
@@ -20,6 +24,7 @@ var (
 	db         *database.Database
 	handler    *endpoint.HttpHandler
 	authorizer *user.Authorizer
+	server     *fasthttp.Server
 )
 
 func main() {
@@ -30,16 +35,47 @@ func main() {
 	setupHttpHandler()
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
-	<-c
+	sig := <-c
+	logrus.Infof("got %s, shutting down", sig)
+	shutdown()
+}
+
+// shutdown stops accepting requests, waits for the active ones, stops parser, saving parsed articles,
+// and closes connection to the database. All of it must be done in server.shutdown-timeout.
+func shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("server.shutdown-timeout"))
+	defer cancel()
+
+	err := server.ShutdownWithContext(ctx)
+	if err != nil {
+		logrus.Errorf("failed to shutdown server, error: %v", err)
+	}
+
+	err = pars.Stop(ctx)
+	if err != nil {
+		logrus.Errorf("failed to stop parser, error: %v", err)
+	}
+
+	// connection is closed even if deadline is exceeded
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), time.Second)
+	defer closeCancel()
+
+	err = db.Close(closeCtx)
+	if err != nil {
+		logrus.Errorf("failed to close database, error: %v", err)
+	}
+
+	logrus.Info("Server stopped")
 }
 
 func setupHttpHandler() {
 	handler = endpoint.NewHttpHandler(pars, authorizer, db)
+	server = &fasthttp.Server{Handler: handler.Handle}
 	go func() {
 		logrus.Info("Server started")
-		err := fasthttp.ListenAndServe(":"+viper.GetString("server.port"), handler.Handle)
+		err := server.ListenAndServe(":" + viper.GetString("server.port"))
 		if err != nil {
 			logrus.Fatal("Listen error: ", err.Error())
 		}
@@ -55,10 +91,14 @@ func setupDatabase() {
 }
 
 func setupParser() {
-	var err error
-	pars, err = parser.NewParser(db)
+	pageFetcher, err := fetcher.NewFetcher()
+	if err != nil {
+		logrus.Fatalf("failed to setup fetcher, error: %v", err)
+	}
+
+	pars, err = parser.NewParser(db, pageFetcher)
 	if err != nil {
-		logrus.Fatalf("failed to setup parser")
+		logrus.Fatalf("failed to setup parser, error: %v", err)
 	}
 
 	pars.Parse()